@@ -2,6 +2,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/siddhesh-tamhanekar/di/lib"
 )
@@ -9,7 +12,44 @@ import (
 func main() {
 	dir := flag.String("path", ".", "Path of the source code directory.")
 	mod := flag.String("module", "", "name of the module optional")
+	container := flag.Bool("container", false, "generate a Container type instead of package level functions and variables.")
+	goos := flag.String("goos", lib.BuildContext.GOOS, "GOOS of the files to scan.")
+	goarch := flag.String("goarch", lib.BuildContext.GOARCH, "GOARCH of the files to scan.")
+	tags := flag.String("tags", "", "comma separated build tags of the files to scan.")
+	output := flag.String("output", lib.Output, "name of the generated files.")
+	autobind := flag.Bool("autobind", false, "bind the interfaces which are not bound to their only implementation.")
+	verbose := flag.Bool("v", false, "print what is parsed and resolved.")
+	tests := flag.Bool("tests", false, "scan the _test.go files too.")
+	// di explain pkg.Type and di why pkg.Type print how the dependencies
+	// are resolved and di lint the unused and duplicate declarations instead
+	// of generating the files.
+	command := ""
+	if len(os.Args) > 1 && (os.Args[1] == "explain" || os.Args[1] == "why" || os.Args[1] == "lint") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
-	// lib.Debug = true
-	lib.Run(*dir, *mod)
+	lib.Container = *container
+	lib.BuildContext.GOOS, lib.BuildContext.GOARCH = *goos, *goarch
+	if *tags != "" {
+		lib.BuildContext.BuildTags = strings.Split(*tags, ",")
+	}
+	lib.Tests = *tests
+	lib.Output = *output
+	lib.AutoBind = *autobind
+	lib.Debug = *verbose
+	if command != "" && command != "lint" && flag.NArg() != 1 {
+		fmt.Println("usage: di " + command + " [flags] pkg.Type")
+		os.Exit(2)
+	}
+	switch command {
+	case "explain":
+		lib.Explain(*dir, *mod, flag.Arg(0))
+	case "why":
+		lib.Why(*dir, *mod, flag.Arg(0))
+	case "lint":
+		lib.Lint(*dir, *mod)
+	default:
+		lib.Run(*dir, *mod)
+	}
 }
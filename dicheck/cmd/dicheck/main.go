@@ -0,0 +1,12 @@
+// dicheck checks the dependency declarations of the packages, it can be run
+// on its own or with go vet -vettool=$(which dicheck).
+package main
+
+import (
+	"github.com/siddhesh-tamhanekar/di/dicheck"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(dicheck.Analyzer)
+}
@@ -0,0 +1,382 @@
+// Package dicheck defines an Analyzer checking the dependency declarations
+// of a package, so the mistakes are shown by go vet and the editors before
+// di is run.
+package dicheck
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/siddhesh-tamhanekar/di/lib"
+	"golang.org/x/tools/go/analysis"
+)
+
+// Analyzer checks the declaration files of a package, it is run by
+// cmd/dicheck and can be added to a multichecker or golangci-lint.
+var Analyzer = &analysis.Analyzer{
+	Name: "dicheck",
+	Doc: `check the dependency declarations of di.go files
+
+The declaration files of the package, di.go, di_test.go and the files built
+with the diinject tag or having a //di:inject comment, are checked for
+unknown types, arguments di does not understand, structs bound to interfaces
+they do not implement and generated files older than the declarations.`,
+	Run: run,
+}
+
+// output is the name of the files generated by di, as set by its -output flag.
+var output string
+
+func init() {
+	Analyzer.Flags.StringVar(&output, "output", lib.Output, "name of the files generated by di")
+}
+
+// checker checks the calls of one declaration file.
+type checker struct {
+	pass *analysis.Pass
+	// dsl is the name the di package is imported under, "." when it is dot
+	// imported, and imports the paths of the other imports by name.
+	dsl     string
+	imports map[string]string
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	tests := false
+	for _, f := range pass.Files {
+		tests = tests || strings.HasSuffix(pass.Fset.File(f.Pos()).Name(), "_test.go")
+	}
+	// declaration files without a build constraint are compiled with the
+	// package, the other ones are ignored by the build and parsed here.
+	files := make(map[string]*ast.File)
+	for _, f := range pass.Files {
+		files[pass.Fset.File(f.Pos()).Name()] = f
+	}
+	for _, name := range pass.IgnoredFiles {
+		files[name] = nil
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		base := filepath.Base(name)
+		if base != "di.go" && base != "di_test.go" && !lib.IsDeclFile(name) {
+			continue
+		}
+		// the package and its test variant both list the files, the
+		// declarations of the tests are checked with the test variant only.
+		if strings.HasSuffix(base, "_test.go") != tests && !strings.HasSuffix(pass.Pkg.Name(), "_test") {
+			continue
+		}
+		f := files[name]
+		if f == nil {
+			var err error
+			f, err = parser.ParseFile(pass.Fset, name, nil, parser.ParseComments)
+			if err != nil || f.Name.Name != pass.Pkg.Name() {
+				continue
+			}
+		}
+		c := checker{pass: pass, imports: make(map[string]string)}
+		for _, im := range f.Imports {
+			path, _ := strconv.Unquote(im.Path.Value)
+			n := path[strings.LastIndex(path, "/")+1:]
+			if im.Name != nil {
+				n = im.Name.Name
+			}
+			if path == lib.DSLPath {
+				c.dsl = n
+			} else {
+				c.imports[n] = path
+			}
+		}
+		if c.dsl == "" || c.dsl == "_" {
+			continue
+		}
+		calls := c.calls(f)
+		for _, call := range calls {
+			c.check(call)
+		}
+		c.checkGenerated(name, calls)
+	}
+	return nil, nil
+}
+
+// calls returns the calls to the di package made in f.
+func (c checker) calls(f *ast.File) []*ast.CallExpr {
+	var calls []*ast.CallExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && c.method(call) != "" {
+			calls = append(calls, call)
+		}
+		return true
+	})
+	return calls
+}
+
+// method returns the name of the di function called by call, "" when it is
+// not a call to the di package.
+func (c checker) method(call *ast.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *ast.SelectorExpr:
+		if x, ok := fun.X.(*ast.Ident); ok && c.dsl != "." && x.Name == c.dsl {
+			return fun.Sel.Name
+		}
+	case *ast.Ident:
+		if _, ok := lib.DSLArgs[fun.Name]; ok && c.dsl == "." {
+			return fun.Name
+		}
+	}
+	return ""
+}
+
+func (c checker) check(call *ast.CallExpr) {
+	method := c.method(call)
+	n, ok := lib.DSLArgs[method]
+	if !ok {
+		c.pass.Reportf(call.Pos(), "unknown function di.%s", method)
+		return
+	}
+	if (n >= 0 && len(call.Args) != n) || len(call.Args) < -n {
+		want := fmt.Sprint(n)
+		if n < 0 {
+			want = fmt.Sprint("at least ", -n)
+		}
+		c.pass.Reportf(call.Pos(), "di.%s expects %s arguments, got %d", method, want, len(call.Args))
+		return
+	}
+	switch method {
+	case "Build", "PostConstruct", "Call", "Config", "FieldsOf":
+		t := c.typeOf(call, call.Args[0])
+		if t == nil {
+			return
+		}
+		switch method {
+		case "PostConstruct", "Call":
+			c.checkMethod(call, t, call.Args[1])
+		case "Config":
+			c.checkString(call, method, call.Args[1])
+		case "FieldsOf":
+			for _, arg := range call.Args[1:] {
+				if name, ok := c.checkString(call, method, arg); ok {
+					if obj, _, _ := types.LookupFieldOrMethod(t, true, c.pass.Pkg, name); obj == nil {
+						c.pass.Reportf(call.Pos(), "%s has no field %s", types.TypeString(t, c.qualifier), name)
+					}
+				}
+			}
+		}
+	case "Share":
+		c.typeOf(call, call.Args[0])
+	case "Bind", "BindEnv":
+		i, t := c.typeOf(call, call.Args[0]), c.typeOf(call, call.Args[1])
+		if method == "BindEnv" {
+			c.checkString(call, method, call.Args[2])
+		}
+		if i == nil || t == nil {
+			return
+		}
+		iface, ok := i.Underlying().(*types.Interface)
+		if !ok {
+			c.pass.Reportf(call.Pos(), "%s is not an interface", types.TypeString(i, c.qualifier))
+			return
+		}
+		if types.Implements(t, iface) || types.Implements(types.NewPointer(t), iface) {
+			return
+		}
+		m, wrongType := types.MissingMethod(types.NewPointer(t), iface, true)
+		problem := "missing method " + m.Name()
+		if wrongType {
+			problem = "wrong type for method " + m.Name()
+		}
+		c.pass.Reportf(call.Pos(), "%s does not implement %s (%s)", types.TypeString(t, c.qualifier), types.TypeString(i, c.qualifier), problem)
+	}
+}
+
+// typeOf returns the type named by the argument e of call, written as T{},
+// &T{}, new(T), (*T)(nil), T(nil), make(T), pkg.T{} or T. It reports the
+// types which can not be resolved and returns nil for them or when the
+// package declaring them is not imported by the package.
+func (c checker) typeOf(call *ast.CallExpr, e ast.Expr) types.Type {
+	t := typeExpr(e)
+	if t == nil {
+		c.pass.Reportf(call.Pos(), "di.%s can not take the type of %s, write T{}, &T{}, new(T) or (*T)(nil)", c.method(call), exprString(e))
+		return nil
+	}
+	switch t := t.(type) {
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType:
+		// unnamed types are resolved by di itself.
+		return nil
+	case *ast.IndexExpr:
+		return c.lookup(call, t.X)
+	case *ast.IndexListExpr:
+		return c.lookup(call, t.X)
+	}
+	return c.lookup(call, t)
+}
+
+// lookup returns the named type e, T or pkg.T, used by call.
+func (c checker) lookup(call *ast.CallExpr, e ast.Expr) types.Type {
+	var obj types.Object
+	switch e := e.(type) {
+	case *ast.Ident:
+		obj = c.pass.Pkg.Scope().Lookup(e.Name)
+		if obj == nil {
+			obj = types.Universe.Lookup(e.Name)
+		}
+		if obj == nil && c.method(call) != "Share" {
+			c.pass.Reportf(call.Pos(), "%s is not declared by package %s", e.Name, c.pass.Pkg.Name())
+		}
+		if obj == nil {
+			// di.Share also takes the variables declared by the declaration files.
+			return nil
+		}
+	case *ast.SelectorExpr:
+		x, ok := e.X.(*ast.Ident)
+		if !ok {
+			return nil
+		}
+		pkg := importedPackage(c.pass.Pkg, c.imports[x.Name])
+		if pkg == nil {
+			return nil
+		}
+		obj = pkg.Scope().Lookup(e.Sel.Name)
+		if obj == nil || !obj.Exported() {
+			c.pass.Reportf(call.Pos(), "%s is not declared by %s", exprString(e), pkg.Path())
+			return nil
+		}
+	default:
+		return nil
+	}
+	if _, ok := obj.(*types.TypeName); !ok {
+		return nil
+	}
+	return obj.Type()
+}
+
+// checkMethod reports the hook of di.PostConstruct or di.Call which is not a
+// method of t, written as (*T).Method, T.Method or "Method".
+func (c checker) checkMethod(call *ast.CallExpr, t types.Type, hook ast.Expr) {
+	name := ""
+	switch h := hook.(type) {
+	case *ast.SelectorExpr:
+		name = h.Sel.Name
+	case *ast.BasicLit:
+		name, _ = c.checkString(call, c.method(call), h)
+	default:
+		c.pass.Reportf(call.Pos(), "di.%s takes the method as (*T).Method or \"Method\"", c.method(call))
+		return
+	}
+	if obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t), true, c.pass.Pkg, name); name != "" {
+		if _, ok := obj.(*types.Func); !ok {
+			c.pass.Reportf(call.Pos(), "%s has no method %s", types.TypeString(t, c.qualifier), name)
+		}
+	}
+}
+
+// checkString reports the argument e of call which is not a string literal.
+func (c checker) checkString(call *ast.CallExpr, method string, e ast.Expr) (string, bool) {
+	if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+		s, err := strconv.Unquote(lit.Value)
+		return s, err == nil
+	}
+	c.pass.Reportf(call.Pos(), "di.%s takes a string literal, got %s", method, exprString(e))
+	return "", false
+}
+
+// checkGenerated reports on the di.Build and di.Bind calls the generated
+// file of the package which is missing or older than the declaration file,
+// and the di.Build calls whose constructor it does not declare.
+func (c checker) checkGenerated(name string, calls []*ast.CallExpr) {
+	gen := output
+	if strings.HasSuffix(name, "_test.go") {
+		gen = strings.TrimSuffix(gen, ".go") + "_test.go"
+	}
+	decl, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	out, err := os.Stat(filepath.Join(filepath.Dir(name), gen))
+	for _, call := range calls {
+		switch method := c.method(call); {
+		case method != "Build" && method != "Bind" && method != "BindEnv":
+			// only constructors are generated for the calls.
+		case err != nil:
+			c.pass.Reportf(call.Pos(), "%s is missing, run di", gen)
+		case out.ModTime().Before(decl.ModTime()):
+			c.pass.Reportf(call.Pos(), "%s is older than %s, run di", gen, filepath.Base(name))
+		case method == "Build" && len(call.Args) == 1 && gen == output && c.pass.Pkg.Scope().Lookup("Container") == nil:
+			// test and Container constructors are named differently.
+			if id, ok := typeExpr(call.Args[0]).(*ast.Ident); ok && c.pass.Pkg.Scope().Lookup(id.Name) != nil && c.pass.Pkg.Scope().Lookup("New"+id.Name) == nil {
+				c.pass.Reportf(call.Pos(), "New%s is not generated, run di", id.Name)
+			}
+		}
+	}
+}
+
+func (c checker) qualifier(p *types.Package) string {
+	if p == c.pass.Pkg {
+		return ""
+	}
+	return p.Name()
+}
+
+// typeExpr returns the type expression of a DSL argument, nil when it does
+// not name a type.
+func typeExpr(e ast.Expr) ast.Expr {
+	switch e := e.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr, *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType:
+		return e
+	case *ast.CompositeLit:
+		return e.Type
+	case *ast.ParenExpr:
+		return typeExpr(e.X)
+	case *ast.StarExpr:
+		return typeExpr(e.X)
+	case *ast.UnaryExpr:
+		if e.Op == token.AND {
+			return typeExpr(e.X)
+		}
+	case *ast.CallExpr:
+		if id, ok := e.Fun.(*ast.Ident); ok && (id.Name == "new" || id.Name == "make") && len(e.Args) > 0 {
+			return typeExpr(e.Args[0])
+		}
+		// conversions such as (*T)(nil) or Clock(nil)
+		return typeExpr(e.Fun)
+	}
+	return nil
+}
+
+// importedPackage returns the package imported as path by pkg or by the
+// packages it imports.
+func importedPackage(pkg *types.Package, path string) *types.Package {
+	seen := make(map[*types.Package]bool)
+	var find func(p *types.Package) *types.Package
+	find = func(p *types.Package) *types.Package {
+		if seen[p] {
+			return nil
+		}
+		seen[p] = true
+		for _, im := range p.Imports() {
+			if im.Path() == path {
+				return im
+			}
+			if found := find(im); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	return find(pkg)
+}
+
+func exprString(e ast.Expr) string {
+	return types.ExprString(e)
+}
@@ -0,0 +1,76 @@
+package dicheck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+// setTimes makes the generated file gen of the package pkg newer than its
+// declaration file decl, or older when stale is set.
+func setTimes(t *testing.T, pkg, decl, gen string, stale bool) {
+	dir := filepath.Join(analysistest.TestData(), "src", pkg)
+	old, now := time.Now().Add(-time.Hour), time.Now()
+	if stale {
+		old, now = now, old
+	}
+	if err := os.Chtimes(filepath.Join(dir, decl), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, gen), now, now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBadForm(t *testing.T) {
+	setTimes(t, "badform", "wire.go", "di_gen.go", false)
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "badform")
+}
+
+func TestBind(t *testing.T) {
+	setTimes(t, "bind", "wire.go", "di_gen.go", false)
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "bind")
+}
+
+func TestStale(t *testing.T) {
+	setTimes(t, "stale", "wire.go", "di_gen.go", true)
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "stale")
+}
+
+func TestOutput(t *testing.T) {
+	setTimes(t, "output", "wire.go", "wire_gen.go", false)
+	Analyzer.Flags.Set("output", "wire_gen.go")
+	defer Analyzer.Flags.Set("output", "di_gen.go")
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "output")
+}
+
+// ignored records the errors of analysistest, which does not read the want
+// comments of the files ignored by the build and reports their diagnostics
+// as unexpected.
+type ignored []string
+
+func (i *ignored) Errorf(format string, args ...interface{}) {
+	*i = append(*i, fmt.Sprintf(format, args...))
+}
+
+func TestUnknown(t *testing.T) {
+	setTimes(t, "unknown", "di.go", "di_gen.go", false)
+	var errs ignored
+	results := analysistest.Run(&errs, analysistest.TestData(), Analyzer, "unknown")
+	var got []string
+	for _, r := range results {
+		for _, d := range r.Diagnostics {
+			p := r.Pass.Fset.Position(d.Pos)
+			got = append(got, fmt.Sprintf("%s:%d: %s", filepath.Base(p.Filename), p.Line, d.Message))
+		}
+	}
+	want := []string{"di.go:9: Missing is not declared by package unknown"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got diagnostics\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,13 @@
+module github.com/siddhesh-tamhanekar/di/dicheck
+
+go 1.25.0
+
+require (
+	github.com/siddhesh-tamhanekar/di v0.0.0-20261019155235-5719cad816ed
+	golang.org/x/tools v0.44.0
+)
+
+require (
+	golang.org/x/mod v0.35.0 // indirect
+	golang.org/x/sync v0.20.0 // indirect
+)
@@ -0,0 +1,3 @@
+package badform
+
+type App struct{}
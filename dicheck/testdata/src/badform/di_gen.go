@@ -0,0 +1,3 @@
+package badform
+
+func NewApp() App { return App{} }
@@ -0,0 +1,11 @@
+//di:inject
+
+package badform
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func build() {
+	di.Build(App{})
+	di.Build(1)                 // want `di.Build can not take the type of 1`
+	di.Config(App{}, "a" + "b") // want `di.Config takes a string literal, got "a" \+ "b"`
+}
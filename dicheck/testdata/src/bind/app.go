@@ -0,0 +1,11 @@
+package bind
+
+type Store interface {
+	Get(key string) string
+}
+
+type Mem struct{}
+
+type Disk struct{}
+
+func (d *Disk) Get(key string) string { return key }
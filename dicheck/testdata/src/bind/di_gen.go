@@ -0,0 +1,3 @@
+package bind
+
+func NewStore() Store { return &Disk{} }
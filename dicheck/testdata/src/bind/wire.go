@@ -0,0 +1,10 @@
+//di:inject
+
+package bind
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func build() {
+	di.Bind((*Store)(nil), Disk{})
+	di.BindEnv((*Store)(nil), Mem{}, "test") // want `Mem does not implement Store \(missing method Get\)`
+}
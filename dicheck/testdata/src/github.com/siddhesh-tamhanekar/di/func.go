@@ -0,0 +1,17 @@
+package di
+
+func Share(src any, code any) {}
+
+func Bind(src any, dest any) {}
+
+func BindEnv(src any, dest any, env string) {}
+
+func Build(src any) {}
+
+func PostConstruct(src any, hook any) {}
+
+func Call(src any, method any) {}
+
+func Config(src any, file string) {}
+
+func FieldsOf(src any, fields ...string) {}
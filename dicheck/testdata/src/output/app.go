@@ -0,0 +1,5 @@
+package output
+
+type App struct{}
+
+type Other struct{}
@@ -0,0 +1,10 @@
+//di:inject
+
+package output
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func build() {
+	di.Build(App{})
+	di.Build(Other{}) // want `NewOther is not generated, run di`
+}
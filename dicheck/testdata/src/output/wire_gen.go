@@ -0,0 +1,3 @@
+package output
+
+func NewApp() App { return App{} }
@@ -0,0 +1,5 @@
+package stale
+
+type App struct{}
+
+type Other struct{}
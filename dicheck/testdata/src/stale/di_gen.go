@@ -0,0 +1,3 @@
+package stale
+
+func NewApp() App { return App{} }
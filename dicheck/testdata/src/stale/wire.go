@@ -0,0 +1,10 @@
+//di:inject
+
+package stale
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func build() {
+	di.Build(App{})   // want `di_gen.go is older than wire.go, run di`
+	di.Build(Other{}) // want `di_gen.go is older than wire.go, run di`
+}
@@ -0,0 +1,3 @@
+package unknown
+
+type App struct{}
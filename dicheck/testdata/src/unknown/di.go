@@ -0,0 +1,10 @@
+//go:build exclude
+
+package unknown
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func build() {
+	di.Build(App{})
+	di.Build(Missing{})
+}
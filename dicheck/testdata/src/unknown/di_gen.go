@@ -0,0 +1,3 @@
+package unknown
+
+func NewApp() App { return App{} }
@@ -3,7 +3,7 @@
 package example
 
 import (
-	di "di/lib"
+	"github.com/siddhesh-tamhanekar/di"
 )
 
 func NewUserHandler() UserHandler {
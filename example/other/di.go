@@ -2,6 +2,10 @@
 
 package other
 
+import (
+	"github.com/siddhesh-tamhanekar/di"
+)
+
 func build() {
 
 	di.Build(Other{})
@@ -14,3 +14,19 @@ func BindEnv(src any, dest any, env string) {
 func Build(src any) {
 
 }
+
+func PostConstruct(src any, hook any) {
+
+}
+
+func Call(src any, method any) {
+
+}
+
+func Config(src any, file string) {
+
+}
+
+func FieldsOf(src any, fields ...string) {
+
+}
@@ -1,3 +1,5 @@
 module github.com/siddhesh-tamhanekar/di
 
 go 1.19
+
+require golang.org/x/mod v0.14.0
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"go/build"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BuildContext selects the files which are scanned by their build
+// constraints and GOOS/GOARCH suffixes, it defaults to the ones of the go
+// command.
+var BuildContext = build.Default
+
+// Tests makes the _test.go files part of the scanned packages.
+var Tests bool
+
+// matchFile reports whether the file name in dir is part of the packages
+// built for BuildContext.
+func matchFile(dir, name string) bool {
+	if strings.HasSuffix(name, "_test.go") && !Tests {
+		return false
+	}
+	ok, err := BuildContext.MatchFile(dir, name)
+	if err != nil {
+		logMsg("[Parser] Skipped " + filepath.Join(dir, name) + ": " + err.Error())
+	}
+	return ok
+}
+
+// skipDir reports whether the directory name in dir is not scanned, as the go
+// command ignores it or it is the root of another module.
+func skipDir(dir, name string) bool {
+	if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(dir, name, "go.mod"))
+	return err == nil
+}
+
+// IsDeclFile reports whether the file declares dependencies, as it is built
+// only with the diinject tag or has a //di:inject comment before its package
+// clause.
+func IsDeclFile(file string) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return false
+	}
+	for _, g := range f.Comments {
+		if g.Pos() > f.Package {
+			break
+		}
+		for _, c := range g.List {
+			if c.Text == "//di:inject" {
+				return true
+			}
+			if expr, err := constraint.Parse(c.Text); err == nil {
+				with := expr.Eval(func(tag string) bool { return tag == "diinject" || hasTag(tag) })
+				without := expr.Eval(hasTag)
+				if with && !without {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// hasTag reports whether the build tag is satisfied by BuildContext.
+func hasTag(tag string) bool {
+	if tag == BuildContext.GOOS || tag == BuildContext.GOARCH {
+		return true
+	}
+	for _, t := range append(BuildContext.BuildTags, BuildContext.ReleaseTags...) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
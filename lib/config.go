@@ -0,0 +1,280 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getConfigField generates the code reading the field f of s from the
+// command line flag or environment variable named in its di tag, and returns
+// the variable holding the value. ok is false when the field has no such tag.
+// The value is only read when guard, if given, is true.
+func getConfigField(s *Struct, f *Field, guard string) (ref string, code string, imports []string, ok bool) {
+	slice := strings.HasPrefix(f.Type, "[]")
+	tag := parseDiTag(f.Tag, slice)
+	env, name, def := tag["env"], tag["flag"], tag["default"]
+	for _, k := range []string{"env", "flag", "config", "default"} {
+		if slice && strings.Contains(def, ","+k+"=") {
+			fmt.Println("default of field " + s.Name + "." + f.Name + " has to be the last option of its di tag as it takes the rest of the tag")
+			os.Exit(1)
+		}
+	}
+	if env == "" && name == "" {
+		return
+	}
+	ref = getVarName(s.Name) + strings.ToUpper(f.Name[0:1]) + f.Name[1:]
+	parse, imports, supported := parseConfigValue(ref, f.Type, s.Name+"."+f.Name)
+	if !supported {
+		fmt.Println("field " + s.Name + "." + f.Name + " of type " + f.Type + " can not be read from flags or environment variables")
+		os.Exit(1)
+	}
+	source := "_"
+	if strings.Contains(parse, "source") {
+		source = "source"
+	}
+	if guard != "" {
+		guard = " && " + guard
+	}
+	code = "var " + ref + " " + f.Type + "\nif raw, " + source + ", ok := configValue(" + strconv.Quote(env) + ", " + strconv.Quote(name) + ", " + strconv.Quote(def) + "); ok" + guard + " {\n" + parse + "\n}"
+	return ref, code, append(imports, "flag", "os", "strconv"), true
+}
+
+// parseConfigValue generates the code parsing the string raw into the
+// variable dst of type typ.
+func parseConfigValue(dst, typ, field string) (code string, imports []string, ok bool) {
+	fail := "if e != nil {\nerr = &ConfigError{Field: " + strconv.Quote(field) + ", Source: source, Value: raw, Err: e}\nreturn\n}\n"
+	bits := func(t, prefix string) string {
+		if n := strings.TrimPrefix(t, prefix); n != "" {
+			return n
+		}
+		return "0"
+	}
+	switch {
+	case strings.HasPrefix(typ, "[]"):
+		elem, im, ok := parseConfigValue("x", typ[2:], field)
+		if !ok {
+			return "", nil, false
+		}
+		return "for _, raw := range strings.Split(raw, \",\") {\nvar x " + typ[2:] + "\n" + elem + "\n" + dst + " = append(" + dst + ", x)\n}", append(im, "strings"), true
+	case strings.HasPrefix(typ, "*"):
+		elem, im, ok := parseConfigValue("x", typ[1:], field)
+		if !ok {
+			return "", nil, false
+		}
+		return "var x " + typ[1:] + "\n" + elem + "\n" + dst + " = &x", im, true
+	case typ == "string" || typ == "any" || typ == "interface{}":
+		return dst + " = raw", nil, true
+	case typ == "bool":
+		return "n, e := strconv.ParseBool(raw)\n" + fail + dst + " = n", nil, true
+	case typ == "byte":
+		return "n, e := strconv.ParseUint(raw, 10, 8)\n" + fail + dst + " = byte(n)", nil, true
+	case typ == "uintptr":
+		return "n, e := strconv.ParseUint(raw, 10, 0)\n" + fail + dst + " = uintptr(n)", nil, true
+	case strings.HasPrefix(typ, "uint"):
+		return "n, e := strconv.ParseUint(raw, 10, " + bits(typ, "uint") + ")\n" + fail + dst + " = " + typ + "(n)", nil, true
+	case strings.HasPrefix(typ, "int"):
+		return "n, e := strconv.ParseInt(raw, 10, " + bits(typ, "int") + ")\n" + fail + dst + " = " + typ + "(n)", nil, true
+	case strings.HasPrefix(typ, "float"):
+		return "n, e := strconv.ParseFloat(raw, " + bits(typ, "float") + ")\n" + fail + dst + " = " + typ + "(n)", nil, true
+	case strings.HasPrefix(typ, "complex"):
+		return "n, e := strconv.ParseComplex(raw, " + bits(typ, "complex") + ")\n" + fail + dst + " = " + typ + "(n)", nil, true
+	case typ == "time.Duration":
+		return "n, e := time.ParseDuration(raw)\n" + fail + dst + " = n", []string{"time"}, true
+	}
+	return "", nil, false
+}
+
+// parseDiTag splits the di tag of a field into its key=value options. The
+// default option of a slice field takes the rest of the tag so the value can
+// contain commas, it ends at the next comma for the other fields.
+func parseDiTag(raw string, slice bool) map[string]string {
+	options := make(map[string]string)
+	tag := getTag(raw, "di")
+	for tag != "" {
+		kv := strings.SplitN(tag, ",", 2)
+		if slice && strings.HasPrefix(tag, "default=") {
+			kv = []string{tag}
+		}
+		tag = ""
+		if len(kv) > 1 {
+			tag = kv[1]
+		}
+		o := strings.SplitN(kv[0], "=", 2)
+		if len(o) == 2 {
+			options[o[0]] = o[1]
+		}
+	}
+	return options
+}
+
+// getConfigPath generates the code reading the field f of s from the
+// configuration file declared with di.Config which holds the path of its
+// config tag, checking the path exists. ok is false when the field has no
+// config tag.
+func getConfigPath(s *Struct, f *Field, pkg string) (ref string, code, args, imports, returns []string, ok bool) {
+	path := parseDiTag(f.Tag, strings.HasPrefix(f.Type, "[]"))["config"]
+	if path == "" {
+		return
+	}
+	var names []string
+	for _, p := range removeDuplicateStr([]string{pkg, s.File.Package}) {
+		for n := range pkgs[p].Configs {
+			names = append(names, p+"."+n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		cfg, _ := getStructOrInterface(strings.Split(n, ".")[1], strings.Split(n, ".")[0])
+		fields, typ := getConfigFields(cfg, strings.Split(path, "."), pkgs[cfg.File.Package].Configs[cfg.Name])
+		if fields == "" {
+			continue
+		}
+		if typ != f.Type {
+			fmt.Println("field " + s.Name + "." + f.Name + " of type " + f.Type + " can not hold " + path + " of type " + typ)
+			os.Exit(1)
+		}
+		code, args, imports, returns = generateFunctionBody(cfg, pkg, false, "")
+		return nodeRef(cfg, pkg, true) + fields, code, args, imports, returns, true
+	}
+	fmt.Println("config path " + path + " of field " + s.Name + "." + f.Name + " not found in " + strings.Join(names, ", "))
+	os.Exit(1)
+	return
+}
+
+// getConfigFields returns the selector of the fields of s matching path and
+// the type of the last one. Keys match the name in the tag of the format of
+// file, or the field name ignoring case as the decoders do.
+func getConfigFields(s *Struct, path []string, file string) (fields string, typ string) {
+	format := getConfigFormat(file)
+	for _, f := range s.Fields {
+		key := strings.Split(getTag(f.Tag, format), ",")[0]
+		if key != path[0] && (key != "" || !strings.EqualFold(f.Name, path[0])) {
+			continue
+		}
+		if len(path) == 1 {
+			return "." + f.Name, f.Type
+		}
+		t, p := strings.TrimPrefix(f.Type, "*"), s.File.Package
+		if strings.Contains(t, ".") {
+			p, t = strings.Split(t, ".")[0], strings.Split(t, ".")[1]
+		}
+		next, _ := getStructOrInterface(t, p)
+		if next == nil {
+			return "", ""
+		}
+		fields, typ = getConfigFields(next, path[1:], file)
+		if fields == "" {
+			return "", ""
+		}
+		return "." + f.Name + fields, typ
+	}
+	return "", ""
+}
+
+func getConfigFormat(file string) string {
+	switch strings.ToLower(file[strings.LastIndex(file, ".")+1:]) {
+	case "yaml", "yml":
+		return "yaml"
+	case "toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// getConfigLoaders generates the functions reading the configuration files
+// declared with di.Config in the package, and the imports they need.
+func getConfigLoaders(pkg Package) (code string, imports []string) {
+	var names []string
+	for n := range pkg.Configs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		decode := map[string]string{
+			"json": "json.Unmarshal(b, &c)",
+			"yaml": "yaml.Unmarshal(b, &c)",
+			"toml": "toml.Unmarshal(b, &c)",
+		}[getConfigFormat(pkg.Configs[n])]
+		imports = append(imports, "os", map[string]string{
+			"json": "encoding/json",
+			"yaml": "gopkg.in/yaml.v3",
+			"toml": "github.com/BurntSushi/toml",
+		}[getConfigFormat(pkg.Configs[n])])
+		code += `
+		// load` + n + ` reads the ` + n + ` from ` + pkg.Configs[n] + `.
+		func load` + n + `() (*` + n + `, error) {
+			b, err := os.ReadFile(` + strconv.Quote(pkg.Configs[n]) + `)
+			if err != nil {
+				return nil, err
+			}
+			var c ` + n + `
+			if err := ` + decode + `; err != nil {
+				return nil, fmt.Errorf("` + pkg.Configs[n] + `: %w", err)
+			}
+			return &c, nil
+		}
+		`
+		imports = append(imports, "fmt")
+	}
+	return
+}
+
+// getTag returns the value of the key in the raw struct tag as written in
+// the source.
+func getTag(raw string, key string) string {
+	if raw == "" {
+		return ""
+	}
+	if tag, err := strconv.Unquote(raw); err == nil {
+		raw = tag
+	}
+	return reflect.StructTag(raw).Get(key)
+}
+
+// getConfigHelpers generates the functions the code reading flags and
+// environment variables relies on.
+func getConfigHelpers() []byte {
+	return []byte(`
+	// ConfigError reports a flag or environment variable which could not be
+	// parsed into the field reading it.
+	type ConfigError struct {
+		Field  string
+		Source string
+		Value  string
+		Err    error
+	}
+
+	func (e *ConfigError) Error() string {
+		return "invalid value " + strconv.Quote(e.Value) + " for " + e.Field + " from " + e.Source + ": " + e.Err.Error()
+	}
+
+	func (e *ConfigError) Unwrap() error {
+		return e.Err
+	}
+
+	// configValue returns the value of the command line flag name when it was
+	// set, of the environment variable env otherwise, falling back to def.
+	func configValue(env, name, def string) (value string, source string, ok bool) {
+		if name != "" {
+			flag.Visit(func(f *flag.Flag) {
+				if f.Name == name {
+					value, source, ok = f.Value.String(), "flag "+name, true
+				}
+			})
+			if ok {
+				return
+			}
+		}
+		if env != "" {
+			if value, ok = os.LookupEnv(env); ok {
+				return value, "env " + env, true
+			}
+		}
+		return def, "default", def != ""
+	}
+	`)
+}
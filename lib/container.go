@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+)
+
+// Container makes the packages declaring dependencies generate a Container
+// type holding the shared values instead of package level variables.
+var Container bool
+
+// receiver prefixes the calls to shared accessors while the body of a
+// Container method is generated.
+var receiver string
+
+func hasMethods(pkg Package) bool {
+	for _, fn := range pkg.Fns {
+		if fn.method {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFunctions(pkg Package) bool {
+	for _, fn := range pkg.Fns {
+		if !fn.method {
+			return true
+		}
+	}
+	return false
+}
+
+// getContainer generates the Container type of the package, its
+// constructor, the lazy accessors of shared values and the Close method.
+func getContainer(pkg Package) []byte {
+	if !hasMethods(pkg) {
+		return nil
+	}
+	params := make(map[string]string)
+	for _, fn := range pkg.Fns {
+		if !fn.method {
+			continue
+		}
+		for _, arg := range fn.args {
+			a := strings.SplitN(arg, " ", 2)
+			params[strings.ToUpper(a[0][0:1])+a[0][1:]] = a[1]
+		}
+	}
+	var names []string
+	for n := range params {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	shared := getProviderNames(pkg)
+
+	code := `
+	// ContainerParams holds the values the dependencies of the Container can not be built from.
+	type ContainerParams struct {
+	`
+	for _, n := range names {
+		code += n + " " + params[n] + "\n"
+	}
+	code += `}
+
+	// Container builds the dependencies of the package. Shared values are
+	// created on first use and released by Close.
+	type Container struct {
+		params ContainerParams
+	` + getSharedFields(pkg) + `}
+
+	// NewContainer returns a Container building its dependencies from params.
+	func NewContainer(params ContainerParams) *Container {
+		return &Container{params: params}
+	}
+	`
+	code += string(getSharedAccessors(pkg, "c."))
+
+	code += `
+	// Close releases the shared values created by the Container.
+	func (c *Container) Close() (err error) {
+	`
+	for _, n := range shared {
+		// the method is looked up in the package of the shared type, for e.g. sql.DB.
+		p, t := pkg.name, n
+		if i := strings.Index(baseName(n), "."); i >= 0 {
+			p, t = n[:i], n[i+1:]
+		}
+		m := getStructMethod(t, "Close", p)
+		if m == nil {
+			continue
+		}
+		code += "if c." + getVarName(n) + " != nil {\n"
+		if len(m.Results) == 1 && m.Results[0].T == "error" {
+			code += "if e := c." + getVarName(n) + ".Close(); e != nil && err == nil {\nerr = e\n}\n"
+		} else {
+			code += "c." + getVarName(n) + ".Close()\n"
+		}
+		code += "}\n"
+	}
+	code += "return\n}\n"
+	return []byte(code)
+}
@@ -4,10 +4,11 @@ import (
 	"fmt"
 	"go/ast"
 	"go/format"
+	"go/parser"
 	"go/printer"
 	"go/token"
-	"log"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 )
@@ -16,11 +17,22 @@ var diassignments map[string]*Di
 
 var pkgs map[string]Package
 
+// Output is the name of the file generated in every package declaring
+// dependencies.
+var Output = "di_gen.go"
+
+// testOutput returns the name of the file generated from the test declarations.
+func testOutput() string {
+	return strings.TrimSuffix(Output, ".go") + "_test.go"
+}
+
 type Function struct {
-	name string
-	args []string
-	ret  []string
-	code string
+	name    string
+	args    []string
+	ret     []string
+	code    string
+	method  bool
+	imports []string
 }
 
 type Package struct {
@@ -31,8 +43,41 @@ type Package struct {
 	Methods    []*Method
 	Imports    []string
 	Fns        map[string]Function
-	Vars       []string
 	Shared     map[string]string
+	Options    map[string]string
+	Providers  map[string]string
+	Accessors  map[string]Function
+	Hooks      map[string][]string
+	Calls      map[string][]string
+	Configs    map[string]string
+	FieldsOf   map[string]string
+	Vars       map[string]string
+	// Names holds the names declared by the package and Forwards the option
+	// types of the packages whose generated constructors it calls, by name.
+	Names    map[string]bool
+	Forwards map[string]string
+	// external is set for the packages loaded from GOROOT, the vendor
+	// directory or the module cache, no file is generated for them.
+	external bool
+}
+
+func newPackage(name, path string) Package {
+	return Package{
+		name:      name,
+		path:      path,
+		Fns:       make(map[string]Function),
+		Shared:    make(map[string]string),
+		Options:   make(map[string]string),
+		Providers: make(map[string]string),
+		Accessors: make(map[string]Function),
+		Hooks:     make(map[string][]string),
+		Calls:     make(map[string][]string),
+		Configs:   make(map[string]string),
+		FieldsOf:  make(map[string]string),
+		Vars:      make(map[string]string),
+		Names:     make(map[string]bool),
+		Forwards:  make(map[string]string),
+	}
 }
 
 type Di struct {
@@ -43,45 +88,123 @@ type Di struct {
 	pkg    string
 	call   bool
 	env    string
+	fields map[string]string
+	// pointer is set when the declaration was written as &T{}, new(T) or (*T)(nil).
+	pointer bool
+	// interPkg is the package of the bound interface and aliases the
+	// imports of the file declaring it.
+	interPkg string
+	aliases  map[string]string
+
+	container bool
+	imports   []string
+	// pos is the position of the declaration and directive the //di:
+	// comment it was declared with.
+	pos       string
+	directive string
+	test      bool
 }
 
 type visitor struct {
 	v             int
 	diassignments map[string]*Di
 	pkg           string
+	imports       map[string]string
+	// dsl is the name the di package is imported under in the current file,
+	// "." when it is dot imported.
+	dsl string
+	// test is set while the test declarations are walked, they replace the
+	// ones of di.go.
+	test bool
+}
+
+// DSLPath is the import path of the package declaring the DSL functions.
+const DSLPath = "github.com/siddhesh-tamhanekar/di"
+
+// DSLArgs holds the number of arguments of each DSL function, negative for
+// variadic functions needing at least its absolute value.
+var DSLArgs = map[string]int{
+	"Share":         2,
+	"Build":         1,
+	"Bind":          2,
+	"BindEnv":       3,
+	"PostConstruct": 2,
+	"Call":          2,
+	"Config":        2,
+	"FieldsOf":      -1,
 }
 
+// rootFields holds the fields set in the literal of the declaration whose
+// constructor is being generated.
+var rootFields map[string]string
+
 func (v visitor) Visit(n ast.Node) ast.Visitor {
 	if n == nil {
 		return nil
 	}
+	if file, ok := n.(*ast.File); ok {
+		v.imports = make(map[string]string)
+		v.dsl = ""
+		for _, im := range file.Imports {
+			path := strings.Trim(im.Path.Value, "\"")
+			name := importName(path)
+			if im.Name != nil {
+				name = im.Name.Name
+			}
+			if path == DSLPath && name != "_" {
+				v.dsl = name
+				continue
+			}
+			v.imports[name] = path
+		}
+		if v.dsl == "" && (!testWiring || v.test) {
+			// the calls of the file can not be told apart from other packages.
+			fmt.Println("warning: " + fileSet.Position(file.Pos()).Filename + " does not import " + DSLPath + ", its declarations are ignored")
+		}
+	}
 	callExpr, ok := n.(*ast.CallExpr)
 	if ok {
-		sel, ok := callExpr.Fun.(*ast.SelectorExpr)
-		if ok && sel.X.(*ast.Ident).Name == "di" {
+		method := ""
+		switch fun := callExpr.Fun.(type) {
+		case *ast.SelectorExpr:
+			if v.dsl != "" && v.dsl != "." && isIdent(fun.X, v.dsl) {
+				method = fun.Sel.Name
+				if _, ok := DSLArgs[method]; !ok {
+					fmt.Println("unknown function " + v.dsl + "." + method + " in package " + v.pkg)
+					os.Exit(1)
+				}
+			}
+		case *ast.Ident:
+			if _, ok := DSLArgs[fun.Name]; ok && v.dsl == "." {
+				method = fun.Name
+			}
+		}
+		if method != "" {
+			if n := DSLArgs[method]; (n >= 0 && len(callExpr.Args) != n) || len(callExpr.Args) < -n {
+				want := fmt.Sprint(n)
+				if n < 0 {
+					want = fmt.Sprint("at least ", -n)
+				}
+				fmt.Printf("di.%s expects %s arguments, got %d in package %s\n", method, want, len(callExpr.Args), v.pkg)
+				os.Exit(1)
+			}
 			// fmt.Printf("%#v\n\n", callExpr.Args[0])
 			var d Di
-			d.method = sel.Sel.Name
+			d.method = method
 			d.pkg = v.pkg
+			d.pos = fileSet.Position(callExpr.Pos()).String()
 
-			switch callExpr.Args[0].(type) {
-			case *ast.CompositeLit:
-				switch callExpr.Args[0].(*ast.CompositeLit).Type.(type) {
-				case *ast.Ident:
-					d.src = callExpr.Args[0].(*ast.CompositeLit).Type.(*ast.Ident).Name
-					break
-				case *ast.SelectorExpr:
-					d.src = callExpr.Args[0].(*ast.CompositeLit).Type.(*ast.SelectorExpr).Sel.Name
-					d.pkg = callExpr.Args[0].(*ast.CompositeLit).Type.(*ast.SelectorExpr).X.(*ast.Ident).Name
-					break
+			if src, pkg, pointer := typeOf(callExpr.Args[0]); src != "" {
+				d.src, d.pointer = src, pointer
+				if pkg != "" {
+					d.pkg = pkg
 				}
-				break
-			case *ast.BasicLit:
-				d.src = strings.Trim(callExpr.Args[0].(*ast.BasicLit).Value, "\"")
-				break
-			case *ast.Ident:
-				d.src = callExpr.Args[0].(*ast.Ident).Name
-				break
+			}
+
+			d.aliases = v.imports
+			if d.method == "Share" && d.pkg != v.pkg {
+				// shared values belong to the declaring package.
+				d.src, d.pkg = d.pkg+"."+d.src, v.pkg
 			}
 
 			if d.method == "Share" {
@@ -91,20 +214,41 @@ func (v visitor) Visit(n ast.Node) ast.Visitor {
 				printer.Fprint(buf, fset, callExpr.Args[1])
 				d.code = buf.String()
 				_, d.call = callExpr.Args[1].(*ast.CallExpr)
+				d.imports = v.exprImports(callExpr.Args[1])
+			}
+
+			if d.method == "Config" {
+				if file, ok := callExpr.Args[1].(*ast.BasicLit); ok {
+					d.code = strings.Trim(file.Value, "\"`")
+				}
+			}
+
+			if d.method == "FieldsOf" {
+				var fields []string
+				for _, arg := range callExpr.Args[1:] {
+					if f, ok := arg.(*ast.BasicLit); ok {
+						fields = append(fields, strings.Trim(f.Value, "\"`"))
+					}
+				}
+				d.code = strings.Join(fields, ",")
+			}
+
+			if d.method == "PostConstruct" || d.method == "Call" {
+				switch hook := callExpr.Args[1].(type) {
+				case *ast.SelectorExpr:
+					d.code = hook.Sel.Name
+				case *ast.BasicLit:
+					d.code = strings.Trim(hook.Value, "\"")
+				}
 			}
 
 			if d.method == "Bind" || d.method == "BindEnv" {
-				d.inter = d.src
-				switch callExpr.Args[1].(type) {
-				case *ast.CompositeLit:
-					switch callExpr.Args[1].(*ast.CompositeLit).Type.(type) {
-					case *ast.Ident:
-						d.src = callExpr.Args[1].(*ast.CompositeLit).Type.(*ast.Ident).Name
-						break
-					case *ast.SelectorExpr:
-						d.src = callExpr.Args[1].(*ast.CompositeLit).Type.(*ast.SelectorExpr).Sel.Name
-						d.pkg = callExpr.Args[1].(*ast.CompositeLit).Type.(*ast.SelectorExpr).X.(*ast.Ident).Name
-						break
+				d.inter, d.pointer = d.src, false
+				d.interPkg, d.pkg = d.pkg, v.pkg
+				if src, pkg, pointer := typeOf(callExpr.Args[1]); src != "" {
+					d.src, d.pointer = src, pointer
+					if pkg != "" {
+						d.pkg = pkg
 					}
 				}
 
@@ -117,14 +261,40 @@ func (v visitor) Visit(n ast.Node) ast.Visitor {
 				}
 			}
 
+			lit := callExpr.Args[0]
+			if d.method == "Bind" || d.method == "BindEnv" {
+				lit = callExpr.Args[1]
+			}
+			if u, ok := lit.(*ast.UnaryExpr); ok && u.Op == token.AND {
+				lit = u.X
+			}
+			if cl, ok := lit.(*ast.CompositeLit); ok && isConstructor(&d) && len(cl.Elts) > 0 {
+				d.fields = make(map[string]string)
+				for _, elt := range cl.Elts {
+					kv, ok := elt.(*ast.KeyValueExpr)
+					if !ok {
+						continue
+					}
+					buf := new(strings.Builder)
+					printer.Fprint(buf, token.NewFileSet(), kv.Value)
+					d.fields[kv.Key.(*ast.Ident).Name] = buf.String()
+					d.imports = append(d.imports, v.exprImports(kv.Value)...)
+				}
+			}
+
 			// fmt.Printf("%#v\n\n", d)
 
+			k := d.src
 			if d.inter != "" {
-				k := strings.Title(d.env) + d.inter
-				v.diassignments[k] = &d
-			} else {
-				v.diassignments[d.src] = &d
+				k = strings.Title(d.env) + d.inter
+			} else if d.method == "PostConstruct" || d.method == "Call" || d.method == "FieldsOf" {
+				k = d.method + "." + d.src + "." + d.code
+			}
+			if prev, ok := v.diassignments[k]; ok && (!v.test || prev.test) {
+				duplicates = append(duplicates, describe(&d)+" replaces "+describe(prev))
 			}
+			d.test = v.test
+			v.diassignments[k] = &d
 		}
 	}
 
@@ -133,21 +303,134 @@ func (v visitor) Visit(n ast.Node) ast.Visitor {
 	return v
 }
 
+// exprImports returns the import paths of the packages used in e.
+func (v visitor) exprImports(e ast.Expr) (imports []string) {
+	ast.Inspect(e, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && v.imports[x.Name] != "" {
+				imports = append(imports, v.imports[x.Name])
+			}
+		}
+		return true
+	})
+	return
+}
+
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+// typeOf returns the type named by a DSL argument such as T{}, &T{}, new(T),
+// (*T)(nil), pkg.T{} or a bare identifier, and whether it was written as a pointer.
+func typeOf(e ast.Expr) (name string, pkg string, pointer bool) {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name, "", false
+	case *ast.BasicLit:
+		return strings.Trim(e.Value, "\""), "", false
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok {
+			return e.Sel.Name, x.Name, false
+		}
+	case *ast.CompositeLit:
+		return typeOf(e.Type)
+	case *ast.IndexExpr:
+		// instantiated generic types such as Repo[User]
+		name, pkg, _ = typeOf(e.X)
+		return name + "[" + exprString(e.Index) + "]", pkg, false
+	case *ast.IndexListExpr:
+		var args []string
+		for _, i := range e.Indices {
+			args = append(args, exprString(i))
+		}
+		name, pkg, _ = typeOf(e.X)
+		return name + "[" + strings.Join(args, ", ") + "]", pkg, false
+	case *ast.ParenExpr:
+		return typeOf(e.X)
+	case *ast.StarExpr:
+		name, pkg, _ = typeOf(e.X)
+		return name, pkg, true
+	case *ast.UnaryExpr:
+		if e.Op == token.AND {
+			name, pkg, _ = typeOf(e.X)
+			return name, pkg, true
+		}
+	case *ast.CallExpr:
+		if isIdent(e.Fun, "new") && len(e.Args) == 1 {
+			name, pkg, _ = typeOf(e.Args[0])
+			return name, pkg, true
+		}
+		if isIdent(e.Fun, "make") && len(e.Args) > 0 {
+			return typeOf(e.Args[0])
+		}
+		// conversions such as (*Writer)(nil) or Port(0)
+		if len(e.Args) == 1 {
+			return typeOf(e.Fun)
+		}
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType:
+		return exprString(e), "", false
+	}
+	return "", "", false
+}
+
 func Run(dir string, mod string) {
+	setup(dir, mod)
+	if generate(dir) {
+		tests := Tests
+		testWiring, Tests = true, true
+		generate(dir)
+		testWiring, Tests = false, tests
+	}
+}
+
+// setup finds the module and the workspace of dir, dir may be the root of a
+// workspace without a module of its own.
+func setup(dir string, mod string) {
+	moduleRoot = findModuleRoot(dir)
+	if moduleRoot == "" && findWorkFile(dir) == "" {
+		fmt.Println("go module does not exists")
+		os.Exit(1)
+	}
+	modulePath = mod
+	scanDir, _ = filepath.Abs(dir)
+	loadWorkspace(dir)
+}
+
+// generate writes the injectors of the packages in dir and reports whether
+// some of them have a di_test.go file. The di_test.go declarations override
+// the di.go ones while testWiring is set.
+func generate(dir string) (tests bool) {
 	vs := visitor{
 		diassignments: make(map[string]*Di),
 	}
 	diassignments = vs.diassignments
 	pkgs = make(map[string]Package)
-	if mod == "" {
-		mod = getCurrentModuleName()
-	}
+	loaded = make(map[string]string)
+	testPkgs = make(map[string]bool)
 
-	diFiles, otherFiles := traversDir(dir)
+	duplicates = nil
+
+	var diFiles, testFiles []*ast.File
+	var otherFiles []*CodeFile
+	for _, root := range scanRoots(dir) {
+		dif, otf, tf := traversDir(root)
+		diFiles = append(diFiles, dif...)
+		otherFiles = append(otherFiles, otf...)
+		testFiles = append(testFiles, tf...)
+	}
 	for _, v := range diFiles {
 		vs.pkg = v.Name.Name
 		ast.Walk(vs, v)
 	}
+	if testWiring {
+		vs.test = true
+		for _, v := range testFiles {
+			vs.pkg = strings.TrimSuffix(v.Name.Name, "_test")
+			ast.Walk(vs, v)
+		}
+		diFiles = append(diFiles, testFiles...)
+	}
 	// spew.Dump(diassignments)
 	for _, file := range otherFiles {
 		if file == nil {
@@ -156,39 +439,125 @@ func Run(dir string, mod string) {
 		pkg, ok := pkgs[file.Package]
 
 		if ok == false {
-			pkg = Package{
-				name:   file.Package,
-				path:   file.Path,
-				Fns:    make(map[string]Function),
-				Shared: make(map[string]string),
-				Vars:   make([]string, 0),
-			}
+			pkg = newPackage(file.Package, file.Path)
 		}
 		pkg.Structs = append(pkg.Structs, file.Structs...)
 		pkg.Methods = append(pkg.Methods, file.Methods...)
 		pkg.Interfaces = append(pkg.Interfaces, file.Interfaces...)
+		for n, t := range file.Vars {
+			pkg.Vars[n] = t
+		}
+		for _, n := range file.Names {
+			pkg.Names[n] = true
+		}
 		pkgs[file.Package] = pkg
 	}
+	for _, file := range diFiles {
+		if pkg, ok := pkgs[strings.TrimSuffix(file.Name.Name, "_test")]; ok {
+			for n, t := range getVars(file) {
+				pkg.Vars[n] = t
+			}
+		}
+	}
+	if testWiring {
+		checkExternalTests()
+	}
+	for name, pkg := range pkgs {
+		for n, t := range pkg.Vars {
+			// variables set to the result of a call, as in var db = NewDb(),
+			// have the type of its first result.
+			if m := getProvider(t, name); strings.Contains(t, "(") && m != nil && len(m.Results) > 0 {
+				pkg.Vars[n] = localType(m.Results[0].T, m.File, name)
+			}
+		}
+	}
 
-	for _, v := range vs.diassignments {
-		generateCode(v)
+	addDirectives(otherFiles)
+	if !tracing {
+		for _, dup := range duplicates {
+			fmt.Println("warning: " + dup)
+		}
 	}
 
-	for _, pkg := range pkgs {
-		generateDiGenFile(pkg, mod)
+	for _, d := range vs.diassignments {
+		for _, path := range d.imports {
+			loadPackage(path)
+		}
+		d.pkg = packageName(d.pkg, d.aliases)
+		d.interPkg = packageName(d.interPkg, d.aliases)
+		if i := strings.Index(baseName(d.src), "."); i >= 0 {
+			d.src = packageName(d.src[:i], d.aliases) + d.src[i:]
+		}
+	}
+	flattenInterfaces()
+
+	// shared values and hooks are registered first so every constructor can use them.
+	var keys []string
+	for k := range vs.diassignments {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		si, sj := !isConstructor(vs.diassignments[keys[i]]), !isConstructor(vs.diassignments[keys[j]])
+		if si != sj {
+			return si
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		v := vs.diassignments[k]
+		v.container = Container
+		generateCode(v)
 	}
 
+	prepareAccessors()
+	var names []string
+	for n := range pkgs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		pkg := pkgs[n]
+		if !pkg.external && (!testWiring || testPkgs[pkg.name]) && !tracing {
+			generateDiGenFile(pkg)
+		}
+	}
+	return len(testFiles) > 0
 }
 
-func generateDiGenFile(pkg Package, mod string) {
+func generateDiGenFile(pkg Package) {
 	pkgbytes := []byte(fmt.Sprintf("package %s\n", pkg.name))
 
 	// fmt.Println("pkgs", pkg.Fns)
 	b := pkgbytes
-	b = append(b, getImports(pkg, mod)...)
-	b = append(b, getSharedVars(pkg.Vars)...)
-	for _, v := range pkg.Fns {
-		b = append(b, []byte(v.code)...)
+	container := getContainer(pkg)
+	shared := []byte{}
+	if len(container) == 0 || hasFunctions(pkg) {
+		shared = getSharedAccessors(pkg, "")
+	}
+	for _, fn := range pkg.Accessors {
+		pkg.Imports = append(pkg.Imports, fn.imports...)
+	}
+	loaders, im := getConfigLoaders(pkg)
+	pkg.Imports = append(pkg.Imports, im...)
+	if len(pkg.Accessors) > 0 {
+		pkg.Imports = append(pkg.Imports, "sync")
+	}
+	b = append(b, getImports(pkg)...)
+	b = append(b, shared...)
+	b = append(b, []byte(loaders)...)
+	b = append(b, getOptions(pkg)...)
+	b = append(b, container...)
+	// the functions are written by name so the generated file is stable.
+	var fns []string
+	for n := range pkg.Fns {
+		fns = append(fns, n)
+	}
+	sort.Strings(fns)
+	for _, n := range fns {
+		b = append(b, []byte(pkg.Fns[n].code)...)
+	}
+	if strings.Contains(string(b), "configValue(") {
+		b = append(b, getConfigHelpers()...)
 	}
 
 	if string(pkgbytes) == string(b) {
@@ -207,35 +576,111 @@ func generateDiGenFile(pkg Package, mod string) {
 		return
 	}
 
-	fp := pkg.path[:strings.LastIndex(pkg.path, "/")] + "/di_gen.go"
+	fp := pkg.path[:strings.LastIndex(pkg.path, "/")] + "/" + Output
+	if testWiring {
+		fp = pkg.path[:strings.LastIndex(pkg.path, "/")] + "/" + testOutput()
+		b = renameDecls(b)
+	}
 	os.Remove(fp)
 	WriteFile(fp, b)
 }
 
-func getSharedVars(vars []string) (b []byte) {
-	if len(vars) > 0 {
-		varstr := "var("
-		for _, v := range vars {
-			varstr += v + "\n"
+// getOptions generates the Option type accepted by every constructor of the
+// package and a With<Name> function for each dependency it can override.
+// The options are held in diOptions so they do not clash with the package.
+func getOptions(pkg Package) []byte {
+	if len(pkg.Fns) == 0 {
+		return nil
+	}
+	var names []string
+	for n := range pkg.Options {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var forwards []string
+	for p := range pkg.Forwards {
+		forwards = append(forwards, p)
+	}
+	sort.Strings(forwards)
+	option := optionType(pkg.name)
+
+	code := `
+	// ` + option + ` overrides a dependency built by the generated constructors.
+	type ` + option + ` func(*diOptions)
+
+	type diOptions struct {
+	`
+	for _, n := range names {
+		code += n + " *" + strings.TrimPrefix(pkg.Options[n], "*") + "\n"
+	}
+	for _, p := range forwards {
+		code += p + "Options []" + pkg.Forwards[p] + "\n"
+	}
+	code += `}
+
+	func newDiOptions(opts []` + option + `) *diOptions {
+		o := &diOptions{}
+		for _, opt := range opts {
+			opt(o)
 		}
-		varstr += ")"
-		b = append(b, []byte(varstr)...)
+		return o
 	}
-	return
+	`
+	for _, n := range names {
+		with := "With" + strings.ToUpper(n[0:1]) + n[1:]
+		if pkg.Names[with] {
+			fmt.Println("warning: " + with + " is declared by package " + pkg.name + ", " + n + " can not be overridden")
+			continue
+		}
+		set := "&v"
+		if strings.HasPrefix(pkg.Options[n], "*") {
+			// values of external types are passed by pointer as they may hold locks.
+			set = "v"
+		}
+		code += "\n// " + with + " makes the generated constructors use v instead of building " + strings.TrimPrefix(pkg.Options[n], "*") + ".\n"
+		code += "func " + with + "(v " + pkg.Options[n] + ") " + option + " {\nreturn func(o *diOptions) {\no." + n + " = " + set + "\n}\n}\n"
+	}
+	for _, p := range forwards {
+		with := "With" + strings.ToUpper(p[0:1]) + p[1:] + "Options"
+		if pkg.Names[with] {
+			fmt.Println("warning: " + with + " is declared by package " + pkg.name + ", the options of package " + p + " can not be forwarded")
+			continue
+		}
+		code += "\n// " + with + " passes opts to the constructors of package " + p + " called by the generated constructors.\n"
+		code += "func " + with + "(opts ..." + pkg.Forwards[p] + ") " + option + " {\nreturn func(o *diOptions) {\no." + p + "Options = append(o." + p + "Options, opts...)\n}\n}\n"
+	}
+	return []byte(code)
+}
+
+// optionType returns the name of the Option type generated in the package
+// pkg, DiOption when the package declares an Option of its own.
+func optionType(pkg string) string {
+	if pkgs[pkg].Names["Option"] {
+		return "DiOption"
+	}
+	return "Option"
+}
+
+// usesOptions reports whether the generated code reads the options diO.
+func usesOptions(code string) bool {
+	return strings.Contains(code, "diO.")
 }
 
-func getImports(pkg Package, mod string) []byte {
+func getImports(pkg Package) []byte {
 	var imports []string
+	self := importPath(filepath.Dir(pkg.path))
 	for _, im := range removeDuplicateStr(pkg.Imports) {
 		if im == "" {
 			continue
 		}
 		// fmt.Println("Import", im)
 		imp := im
-		if strings.Contains(im, ".go") {
-			imp = im[:strings.LastIndex(im, "/")]
-			imp = strings.ReplaceAll(imp, "./", mod+"/")
-
+		if strings.HasSuffix(im, ".go") {
+			imp = importPath(filepath.Dir(im))
+		}
+		if imp == self {
+			// the types of the package itself, as in the type arguments of a generic type.
+			continue
 		}
 		imports = append(imports, "import \""+imp+"\"\n")
 	}
@@ -250,60 +695,155 @@ func generateCode(v *Di) {
 		// fmt.Println("returning", v)
 		return
 	}
-	fn.name = "New" + v.src
+	fn.name = "New" + identName(v.src)
 	pk, ok := pkgs[v.pkg]
 
 	if ok == false {
-		pk = Package{
-			name:   v.pkg,
-			Fns:    make(map[string]Function),
-			Shared: make(map[string]string),
-			Vars:   make([]string, 0),
+		pk = newPackage(v.pkg, "")
+		pkgs[v.pkg] = pk
+	}
+
+	if t, ok := pk.Vars[v.src]; ok && v.method == "Share" && !strings.Contains(t, ".") {
+		if s, _ := getStructOrInterface(v.src, v.pkg); s == nil {
+			delete(diassignments, v.src)
+			v.src = strings.TrimPrefix(t, "*")
+			diassignments[v.src] = v
 		}
 	}
 
 	if v.method == "Share" {
-		if v.call {
-			code := v.code
-			if strings.Contains(code, ".") {
-				code = strings.Split(code, ".")[1]
-			}
-			pk.Vars = append(pk.Vars, getVarName(v.src)+"="+code)
+		if met := getProvider(v.code, v.pkg); v.call || (met != nil && len(met.Results) > 0 && strings.TrimPrefix(localType(met.Results[0].T, met.File, v.pkg), "*") == v.src) {
 			pk.Shared[v.src] = getVarName(v.src)
+			pk.Providers[v.src] = v.code
 
 		} else {
 			pk.Shared[v.src] = v.code
 		}
 	}
 
-	if v.method == "Build" || v.method == "Bind" || v.method == "BindEnv" {
+	if v.method == "Config" {
+		pk.Configs[v.src] = v.code
+		pk.Shared[v.src] = getVarName(v.src)
+		pk.Providers[v.src] = "load" + v.src + "()"
+	}
+
+	if v.method == "FieldsOf" {
 		s, _ := getStructOrInterface(v.src, v.pkg)
-		rootPointer := ""
+		if s == nil {
+			fmt.Println(v.src + " not found")
+			os.Exit(1)
+		}
+		for _, n := range strings.Split(v.code, ",") {
+			var field *Field
+			for _, f := range s.Fields {
+				if f.Name == n {
+					field = f
+				}
+			}
+			if field == nil {
+				fmt.Println("struct " + s.Name + " doesnt have " + n + " field")
+				os.Exit(1)
+			}
+			// the fields are held by their qualified type, for e.g. log.Config.
+			p, t := s.File.Package, strings.TrimPrefix(field.Type, "*")
+			if i := strings.Index(t, "."); i >= 0 {
+				p, t = t[:i], t[i+1:]
+				if path, ok := s.File.Imports[p]; ok {
+					if n := loadPackage(path); n != "" {
+						p = n
+					}
+				}
+			}
+			pk.FieldsOf[p+"."+t] = s.File.Package + "." + s.Name + "." + n
+		}
+	}
+
+	if v.method == "PostConstruct" {
+		pk.Hooks[v.src] = append(pk.Hooks[v.src], v.code)
+	}
+
+	if v.method == "Call" {
+		pk.Calls[v.src] = append(pk.Calls[v.src], v.code)
+	}
+
+	if isConstructor(v) {
+		if pk.external {
+			fmt.Println("can not generate New" + identName(v.src) + " in the external package " + v.pkg + ", use di.Share to provide " + v.src)
+			os.Exit(1)
+		}
+		s, _ := getStructOrInterface(v.src, v.pkg)
+		rootPointer, assertion := "", ""
 		ret := v.src
+		if v.pointer {
+			rootPointer = "&"
+			ret = "*" + v.src
+		}
 		if v.method == "Bind" || v.method == "BindEnv" {
 			ret = v.inter
-			fn.name = "New" + v.inter
-			_, i := getStructOrInterface(v.inter, v.pkg)
-			if len(i.Methods) > 0 {
-				n := i.Methods[0].Name
-				m := getStructMethod(s.Name, n, v.pkg)
-				if m == nil {
-					log.Panic("struct " + s.Name + " doesnt have " + n + " method")
-				}
-				if m.Reciever.T[0:1] == "*" {
-					rootPointer = "&"
-				}
+			fn.name = "New" + identName(v.inter)
+			ip := v.interPkg
+			if ip == "" {
+				ip = v.pkg
+			}
+			_, i := getStructOrInterface(v.inter, ip)
+			if i != nil && i.File.Package != v.pkg {
+				ret = i.File.Package + "." + v.inter
+				pk.Imports = append(pk.Imports, i.File.Path)
+			}
+			if s == nil || i == nil {
+				fmt.Println("can not bind " + v.inter + " to " + v.src + ", one of them is not found")
+				os.Exit(1)
+			}
+			if implements(s, i) {
+				rootPointer = "&"
+			}
+			assertion = implementsAssertion(s, i, v.pkg)
+		}
+		if s == nil {
+			fmt.Println("type " + v.src + " not found in package " + v.pkg)
+			os.Exit(1)
+		}
+		if len(v.fields) > 0 {
+			if met := getConstructor(s); met != nil {
+				fmt.Println("fields of " + s.Name + " can not be set as it is built by " + met.Name)
+				os.Exit(1)
+			}
+		}
+		for n := range v.fields {
+			found := false
+			for _, f := range s.Fields {
+				found = found || f.Name == n
+			}
+			if !found {
+				fmt.Println("struct " + s.Name + " doesnt have " + n + " field")
+				os.Exit(1)
 			}
 		}
+		defer func(f map[string]string) { rootFields = f }(rootFields)
+		rootFields = v.fields
+		pk.Imports = append(pk.Imports, v.imports...)
+		defer func(r string) { receiver = r }(receiver)
+		receiver = ""
+		defer pruneScope()()
+		if v.container {
+			fn.method = true
+			receiver = "c."
+		}
+		end := traceRoot(v)
 		co, ar, im, returns := generateFunctionBody(s, v.pkg, true, rootPointer)
+		end()
 		// fmt.Printf("returned %#v\n", returns)
 		sort.Strings(ar)
 		sort.Strings(returns)
 		fn.args = ar
-		args = strings.Join(ar, ", ")
-		codes = strings.Join(co, "\n")
+		args = strings.Join(append(ar, "diOpts ..."+optionType(v.pkg)), ", ")
+		codes = buildConditions() + strings.Join(co, "\n")
+		if usesOptions(codes) {
+			codes = "diO := newDiOptions(diOpts)\n" + codes
+		}
 		// pkgs[v.pkg] = append(pkgs[v.pkg].Imports, im...)
 		pk.Imports = append(pk.Imports, im...)
+		returns = removeDuplicateStr(returns)
 		rets := append([]string{getVarName(v.src) + " " + ret}, returns...)
 
 		// variable is introduced for special case where share variable is wrapped in function and returned.
@@ -314,14 +854,30 @@ func generateCode(v *Di) {
 			retvar = getVarName(s.Name)
 		}
 		fn.ret = append(fn.ret, rets...)
-		fn.code = generateFunction(fn.name, codes, args, "("+strings.Join(fn.ret, ", ")+")", retvar)
+		if fn.method {
+			params := ""
+			for _, arg := range ar {
+				n := strings.Split(arg, " ")[0]
+				params += n + " := c.params." + strings.ToUpper(n[0:1]) + n[1:] + "\n"
+			}
+			codes = params + codes
+			fn.code = generateFunction("(c *Container) "+strings.TrimPrefix(fn.name, "New"), codes, "diOpts ..."+optionType(v.pkg), "("+strings.Join(fn.ret, ", ")+")", retvar)
+		} else {
+			fn.code = generateFunction(fn.name, codes, args, "("+strings.Join(fn.ret, ", ")+")", retvar)
+		}
 
+		fn.code = assertion + fn.code
 		pk.Fns[fn.name] = fn
 	}
 	pkgs[v.pkg] = pk
 
 }
 
+// isConstructor reports whether the declaration v generates a constructor.
+func isConstructor(v *Di) bool {
+	return v.method == "Build" || v.method == "Bind" || v.method == "BindEnv"
+}
+
 func generateFunction(name, body, args string, ret string, retvar string) string {
 	fnTemplate := `
 	func {NAME}({ARGS}) {RETURNS} {
@@ -343,203 +899,672 @@ func generateFunctionBody(s *Struct, pkg string, root bool, returnPointer string
 	if s == nil {
 		return
 	}
-	co, sharedVariableExists := pkgs[pkg].Shared[s.Name]
-	met := getMethod("New"+s.Name, s.File.Package)
+	node := tracePush(s.File.Package + "." + s.Name)
+	defer tracePop()
+	name := nodeName(s, pkg)
+	defer buildNode(name)()
+	typ := typeIn(s, pkg)
+	external := pkgs[s.File.Package].external
+	if external {
+		imports = append(imports, s.File.Path)
+	}
+	co, sharedVariableExists := pkgs[pkg].Shared[typ]
+	met := getConstructor(s)
 
+	ref := sharedRef(co, pkg)
 	if sharedVariableExists {
-		if getVarName(s.Name) != co {
+		if d, ok := diassignments[typ]; ok && d.method == "Share" && !d.call {
+			imports = append(imports, d.imports...)
+		}
+		if d, ok := diassignments[typ]; ok {
+			node.set("shared by " + describe(d)).declared(d)
+		}
+		if ref != "" && root {
+			if returnPointer != "" {
+				c = name + " := " + ref
+			} else {
+				c = name + " := *" + ref
+			}
+		} else if ref != "" {
+			c = overrideShared(name, typ, pkg, "_"+name+" = "+ref)
+		} else if name != co {
 			if root {
-				c = getVarName(s.Name) + " = " + returnPointer + co
+				c = name + " := " + returnPointer + co
 			} else {
-				c = getVarName(s.Name) + " := " + co
+				c = overrideNode(name, typ, pkg, name+" = "+co)
 			}
+		} else if _, ok := pkgs[pkg].Providers[typ]; ok && !root {
+			acc := getAccessor(pkg, typ)
+			args = append(args, acc.args...)
+			imports = append(imports, acc.imports...)
+			var ar []string
+			for _, arg := range acc.args {
+				ar = append(ar, strings.Split(arg, " ")[0])
+			}
+			call := receiver + acc.name + "(" + strings.Join(ar, ", ") + ")"
+			get := "_" + name + " = " + call
+			if ContainsStr(acc.ret, "error") {
+				get = "_" + name + ", err = " + call + "\nif err != nil {\nreturn\n}"
+				returns = append(returns, "err error")
+			}
+			c = overrideShared(name, typ, pkg, get)
+		} else if !root {
+			c = overrideShared(name, typ, pkg, "_"+name+" = &"+name)
 		}
 	} else if met != nil {
-		var vars, ar []string
-		for _, v := range met.Results {
-			if v.T == "error" {
-				vars = append(vars, "err")
-				continue
+		node.set("built by " + met.Name + " at " + met.Pos)
+		var ar []string
+		results := met.Results
+		call := met.Name
+		if external {
+			// constructors of external packages have their parameters resolved.
+			call = s.File.Package + "." + met.Name
+			for _, v := range met.Params {
+				t, spread := variadicParam(v.T)
+				ref, co, a, im, ret := resolveType(met.File, paramName(name, v), t, pkg, nil)
+				code = append(code, co...)
+				args = append(args, a...)
+				imports = append(imports, im...)
+				returns = append(returns, ret...)
+				ar = append(ar, ref+spread)
 			}
-			varName := getVarName(v.T)
-			if v.T[0:1] == "*" {
-				varName = "_" + varName
+			results = nil
+			for _, r := range met.Results {
+				results = append(results, &Type{Name: r.Name, T: localType(r.T, met.File, pkg)})
 			}
-			vars = append(vars, varName)
-		}
-		for _, v := range met.Params {
-			args = append(args, v.Name+" "+v.T)
-
-		}
-		for _, arg := range args {
-			ar = append(ar, strings.Split(arg, " ")[0])
-		}
-		// if vars[0][0:1] != "_" {
-		if root {
-			c = strings.Join(vars, ", ") + " = " + met.Name + "(" + strings.Join(ar, ", ") + ")"
 		} else {
-			c = strings.Join(vars, ", ") + " := " + met.Name + "(" + strings.Join(ar, ", ") + ")"
-		}
-		// }
-		retPrefix := ""
-		if returnPointer != "" {
-			// fmt.Printf("%#v, %s | %s\n", met.Name, c, returnPointer)
-			if root {
-				c = strings.ReplaceAll(c, "=", ":=")
+			for _, v := range met.Params {
+				t, spread := variadicParam(v.T)
+				n := paramName(name, v)
+				args = append(args, n+" "+t)
+				ar = append(ar, n+spread)
+				traceLeaf(t, "argument "+n)
 			}
-			c = "ret" + c
-			retPrefix = "ret"
 		}
-		if vars[0][0:1] == "_" {
-			ptrPrefix := ""
-			if returnPointer != "" {
-				ptrPrefix = ""
-			}
-			if root {
-				c += "\n " + vars[0][1:] + "=" + ptrPrefix + retPrefix + vars[0]
-			} else {
-				c += "\n " + vars[0][1:] + ":=*" + ptrPrefix + retPrefix + vars[0]
-			}
+		call += "(" + strings.Join(ar, ", ") + ")"
+		calls, co, a, im, ret := getCalls(s, pkg)
+		code = append(code, co...)
+		args = append(args, a...)
+		imports = append(imports, im...)
+		returns = append(returns, ret...)
+		hasErr := false
+		if root && returnPointer != "" && calls != "" {
+			c, hasErr = constructorCall(results, call, "ret"+name, false)
+			c = "var ret" + name + " " + typ + "\n" + c + strings.ReplaceAll(calls, "{VAR}", "ret"+name) + "\n" + name + " = &ret" + name
+		} else if root {
+			c, hasErr = constructorCall(results, call, name, returnPointer != "")
+			c += strings.ReplaceAll(calls, "{VAR}", name)
+		} else if external {
+			c, hasErr = constructorCall(results, call, "_"+name, true)
+			c = overrideShared(name, typ, pkg, c)
 		} else {
-			if returnPointer != "" {
-				if root {
-					c += "\n " + vars[0] + "=" + returnPointer + retPrefix + vars[0]
-				} else {
-					c += "\n " + vars[0] + ":=" + returnPointer + retPrefix + vars[0]
-				}
-
-			}
-
+			c, hasErr = constructorCall(results, call, name, false)
+			c = overrideNode(name, typ, pkg, c+strings.ReplaceAll(calls, "{VAR}", name))
 		}
-		if ContainsStr(vars, "err") {
-			c += "\n if err!=nil{ return }"
+		if hasErr {
 			returns = append(returns, "err error")
 		}
-	} else if s.File.Package != pkg {
+	} else if s.File.Package != pkg && !external && !instantiatedAt(s) {
 		imports = append(imports, s.File.Path)
-		generateCode(&Di{
-			method: "Build",
-			src:    s.Name,
-			pkg:    s.File.Package,
-		})
-		args = append(args, pkgs[s.File.Package].Fns["New"+s.Name].args...)
+		node.set("built by " + s.File.Package + ".New" + identName(s.Name))
+		fn, ok := pkgs[s.File.Package].Fns["New"+identName(s.Name)]
+		if !ok || fn.method {
+			d := &Di{method: "Build", src: s.Name, pkg: s.File.Package}
+			// the declaration made for s in its own package decides what it returns.
+			if dia, ok := diassignments[s.Name]; ok && dia.method == "Build" && dia.pkg == s.File.Package && !dia.container {
+				d = dia
+			}
+			generateCode(d)
+			fn = pkgs[s.File.Package].Fns["New"+identName(s.Name)]
+		}
+		if r := traces[s.File.Package+"."+s.Name]; r != nil && node != nil {
+			node.children = r.children
+		}
+		for _, arg := range fn.args {
+			// the arguments are written as in the package pkg.
+			i := strings.Index(arg, " ")
+			args = append(args, arg[:i+1]+localType(arg[i+1:], s.File, pkg))
+			imports = append(imports, localImports(arg[i+1:], s.File, pkg)...)
+		}
 		var ar []string
 		for _, arg := range args {
 			ar = append(ar, strings.Split(arg, " ")[0])
 		}
+		// the options given to With<Package>Options are passed on.
+		pkgs[pkg].Forwards[s.File.Package] = s.File.Package + "." + optionType(s.File.Package)
+		ar = append(ar, "diO."+s.File.Package+"Options...")
+		call := s.File.Package + ".New" + identName(s.Name) + "(" + strings.Join(ar, ", ") + ")"
+		results := []*Type{{T: typ}}
+		if len(fn.ret) > 0 && strings.HasSuffix(fn.ret[0], "*"+s.Name) {
+			results[0].T = "*" + typ
+		}
+		if ContainsStr(fn.ret, "err error") {
+			results = append(results, &Type{T: "error"})
+		}
+		var hasErr bool
 		if root {
-			c = getVarName(s.Name) + " = " + returnPointer + s.File.Package + ".New" + s.Name + "(" + strings.Join(ar, ", ") + ")"
-
+			c, hasErr = constructorCall(results, call, name, returnPointer != "")
 		} else {
-			c = getVarName(s.Name) + " := " + s.File.Package + ".New" + s.Name + "(" + strings.Join(ar, ", ") + ")"
+			c, hasErr = constructorCall(results, call, name, false)
+			c = overrideNode(name, typ, pkg, c)
 		}
+		if hasErr {
+			returns = append(returns, "err error")
+		}
+	} else if s.Underlying != "" {
+		// named types which are not structs can not be built, they are arguments.
+		node.set("argument " + name)
+		if root {
+			fmt.Println("can not build " + s.Name + " as it is not a struct, share it or declare New" + identName(s.Name))
+			os.Exit(1)
+		}
+		args = append(args, name+" "+typ)
 	} else {
+		node.set("struct literal")
+		if s.File.Package != pkg && !external {
+			// generic types instantiated with the types of pkg are built in pkg.
+			imports = append(imports, s.File.Path)
+			for _, f := range s.Fields {
+				if !token.IsExported(f.Name) {
+					fmt.Println("can not build " + typ + " in package " + pkg + " as its field " + f.Name + " is not exported")
+					os.Exit(1)
+				}
+			}
+		}
 		if root {
-			c = getVarName(s.Name) + "=" + returnPointer + s.Name + "{\n"
+			c = name + "=" + returnPointer + typ + "{\n"
 
+		} else if external {
+			// values of external types are built by pointer as they may hold locks.
+			c = "_" + name + "=&" + typ + "{\n"
 		} else {
-			c = getVarName(s.Name) + ":=" + s.Name + "{\n"
+			c = name + "=" + typ + "{\n"
 		}
 		for _, f := range s.Fields {
-			p := s.File.Package
-			t := f.Type
-			isPointer := false
-			if strings.Contains(f.Type, ".") {
-				p = strings.Split(f.Type, ".")[0]
-				if p[0:1] == "*" {
-					isPointer = true
-					p = p[1:]
-				}
-				t = strings.Split(f.Type, ".")[1]
-			}
-			types := []string{
-				"bool",
-				"string",
-				"int", "int8", "int16", "int32", "int64",
-				"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
-				"byte",
-				"float32", "float64",
-				"complex64", "complex128",
-				"any",
-				"interface{}",
-			}
-			for _, typ := range types {
-				types = append(types, "*"+typ)
-			}
-			if ContainsStr(types, f.Type) {
-				args = append(args, getVarName(f.Name)+" "+f.Type)
-				c += f.Name + ":" + getVarName(f.Name) + ","
-			} else {
-				if t[0:1] == "*" {
-					isPointer = true
-					t = t[1:]
-				}
-				// fmt.Println(t, p)
-				s1, i := getStructOrInterface(t, p)
-				if s1 == nil && i == nil {
-
-					args = append(args, getVarName(f.Name)+" "+f.Type)
-					c += f.Name + ":" + getVarName(f.Name) + ","
-					// spew.Dump(s.File)
-					imports = append(imports, s.File.Imports[p])
-					// fmt.Println(p, " not found")
-				} else {
-					if s1 == nil && i != nil {
-						d := strings.Title(os.Getenv("ENV")) + i.Name
-						dia, ok := diassignments[d]
-						if ok == false {
-							dia = diassignments[i.Name]
-						}
-						if dia == nil {
-							fmt.Println("Interface to Implementation not found for", i.Name)
-							os.Exit(1)
-						}
-						s1, _ = getStructOrInterface(dia.src, dia.pkg)
-						if s1 == nil && i != nil {
-							fmt.Println(dia.src + " not found")
-							os.Exit(1)
-						}
-					}
-					co, ar, im, ret := generateFunctionBody(s1, pkg, false, "")
-					if len(ret) > 0 {
-						returns = append(returns, ret...)
-					}
-
-					prefix := ""
-					if i != nil {
-						t = s1.Name
-						if len(i.Methods) > 0 {
-
-							n := i.Methods[0].Name
-							m := getStructMethod(s1.Name, n, pkg)
-							if m == nil {
-								fmt.Println("struct " + s.Name + " doesnt have " + n + " method")
-								return
-							}
-							if m.Reciever.T[0:1] == "*" {
-								prefix = "&"
-							}
-						}
-
-					}
-					if isPointer {
-						prefix = "&"
-					}
+			if external && !token.IsExported(f.Name) {
+				continue
+			}
+			if expr, ok := rootFields[f.Name]; ok && root {
+				if impl := getLiteralStruct(expr, s.File.Package); impl != nil {
+					ref, co, ar, im, ret := resolveType(s.File, f.Name, f.Type, pkg, impl)
 					code = append(code, co...)
 					args = append(args, ar...)
 					imports = append(imports, im...)
-					c += f.Name + ":" + prefix + getVarName(t) + ",\n"
+					returns = append(returns, ret...)
+					expr = ref
+				} else {
+					traceLeaf(f.Type, "field "+f.Name+" set to "+expr)
 				}
-
+				c += f.Name + ":" + expr + ",\n"
+				continue
+			}
+			if ref, co, ar, im, ret, ok := getConfigPath(s, f, pkg); ok {
+				traceLeaf(f.Type, "field "+f.Name+" read from the configuration")
+				code = append(code, co...)
+				args = append(args, ar...)
+				imports = append(imports, im...)
+				returns = append(returns, ret...)
+				c += f.Name + ":" + ref + ",\n"
+				continue
+			}
+			guard := ""
+			if !root {
+				// the field is read only when s is built.
+				guard = buildVar(name)
+			}
+			if ref, co, im, ok := getConfigField(s, f, guard); ok {
+				traceLeaf(f.Type, "field "+f.Name+" read from the configuration")
+				code = append(code, co)
+				imports = append(imports, im...)
+				returns = append(returns, "err error")
+				c += f.Name + ":" + ref + ",\n"
+				continue
 			}
+			ref, co, ar, im, ret := resolveType(s.File, f.Name, f.Type, pkg, nil)
+			code = append(code, co...)
+			args = append(args, ar...)
+			imports = append(imports, im...)
+			returns = append(returns, ret...)
+			c += f.Name + ":" + ref + ",\n"
 		}
 		c += "\n}"
+		calls, co, ar, im, ret := getCalls(s, pkg)
+		code = append(code, co...)
+		args = append(args, ar...)
+		imports = append(imports, im...)
+		returns = append(returns, ret...)
+		hooks, hasErr := getHooks(s)
+		hooks = calls + hooks
+		if external {
+			hooks, hasErr = "", false
+		}
+		if hooks != "" {
+			if root && returnPointer != "" {
+				c = strings.Replace(c, name+"="+returnPointer, "ret"+name+":=", 1)
+				c += strings.ReplaceAll(hooks, "{VAR}", "ret"+name) + "\n" + name + " = &ret" + name
+			} else {
+				c += strings.ReplaceAll(hooks, "{VAR}", name)
+			}
+		}
+		if hasErr {
+			returns = append(returns, "err error")
+		}
+		if !root && external {
+			c = overrideShared(name, typ, pkg, c)
+		} else if !root {
+			c = overrideNode(name, typ, pkg, c)
+		}
+	}
+	if _, ok := pkgs[pkg].Options[name]; ok && external && s.Underlying == "" {
+		pkgs[pkg].Options[name] = "*" + typ
 	}
 
 	code = append(code, c)
 	return removeDuplicateStr(code), removeDuplicateStr(args), imports, returns
 }
 
+// getHooks generates the calls to the Init and Validate methods of s and to
+// the hooks declared with di.PostConstruct, on the variable {VAR}.
+func getHooks(s *Struct) (code string, hasErr bool) {
+	names := []string{"Init"}
+	names = append(names, pkgs[s.File.Package].Hooks[s.Name]...)
+	names = append(names, "Validate")
+	for _, n := range removeDuplicateStr(names) {
+		m := getStructMethod(s.Name, n, s.File.Package)
+		if m == nil || len(m.Params) > 0 {
+			if n != "Init" && n != "Validate" {
+				fmt.Println("struct " + s.Name + " doesnt have " + n + " method")
+				os.Exit(1)
+			}
+			continue
+		}
+		if len(m.Results) == 1 && m.Results[0].T == "error" {
+			code += "\nif err = {VAR}." + n + "(); err != nil {\nreturn\n}"
+			hasErr = true
+		} else if len(m.Results) == 0 {
+			code += "\n{VAR}." + n + "()"
+		}
+	}
+	return
+}
+
+// getCalls generates the calls to the methods declared with di.Call on the
+// variable {VAR}, building their parameters from the graph.
+func getCalls(s *Struct, pkg string) (calls string, code, args, imports, returns []string) {
+	for _, n := range removeDuplicateStr(pkgs[s.File.Package].Calls[s.Name]) {
+		m := getStructMethod(s.Name, n, s.File.Package)
+		if m == nil {
+			fmt.Println("struct " + s.Name + " doesnt have " + n + " method")
+			os.Exit(1)
+		}
+		var ar []string
+		for _, p := range m.Params {
+			ref, co, a, im, ret := resolveType(m.File, p.Name, strings.TrimPrefix(p.T, "..."), pkg, nil)
+			code = append(code, co...)
+			args = append(args, a...)
+			imports = append(imports, im...)
+			returns = append(returns, ret...)
+			ar = append(ar, ref)
+		}
+		call := "{VAR}." + n + "(" + strings.Join(ar, ", ") + ")"
+		if l := len(m.Results); l > 0 && m.Results[l-1].T == "error" {
+			vars := strings.Repeat("_, ", l-1) + "err"
+			calls += "\nif " + vars + " = " + call + "; err != nil {\nreturn\n}"
+			returns = append(returns, "err error")
+		} else {
+			calls += "\n" + call
+		}
+	}
+	return
+}
+
+var scalarTypes = func() []string {
+	types := []string{
+		"bool",
+		"string",
+		"int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"byte",
+		"float32", "float64",
+		"complex64", "complex128",
+		"any",
+		"interface{}",
+	}
+	for _, typ := range types {
+		types = append(types, "*"+typ)
+	}
+	return types
+}()
+
+// resolveType generates the code building a value of type typ used in file
+// and returns the expression referencing it. Types which can not be built
+// become the argument name. impl, when given, is built instead of the struct
+// typ refers to or bound to the interface typ refers to.
+func resolveType(file *CodeFile, name, typ, pkg string, impl *Struct) (ref string, code, args, imports, returns []string) {
+	p := file.Package
+	t := typ
+	isPointer, byPointer := false, false
+	if strings.Contains(baseName(typ), ".") {
+		p, t = typ[:strings.Index(typ, ".")], typ[strings.Index(typ, ".")+1:]
+		if p[0:1] == "*" {
+			isPointer = true
+			p = p[1:]
+		}
+		if path, ok := file.Imports[p]; ok {
+			if n := loadPackage(path); n != "" {
+				p = n
+			}
+		}
+	}
+	if ContainsStr(scalarTypes, typ) {
+		traceLeaf(typ, "argument "+getVarName(name))
+		return getVarName(name), nil, []string{getVarName(name) + " " + typ}, nil, nil
+	}
+	if !isNamedType(typ) {
+		lt := localType(typ, file, pkg)
+		if _, ok := pkgs[pkg].Shared[lt]; ok {
+			// unnamed types such as map[string]Route are built when shared.
+			s := &Struct{Name: lt, File: &CodeFile{Package: pkg}, Underlying: lt}
+			code, args, imports, returns = generateFunctionBody(s, pkg, false, "")
+			return nodeRef(s, pkg, false), code, args, append(imports, localImports(typ, file, pkg)...), returns
+		}
+		traceLeaf(lt, "argument "+getVarName(name))
+		return getVarName(name), nil, []string{getVarName(name) + " " + lt}, localImports(typ, file, pkg), nil
+	}
+	if t[0:1] == "*" {
+		isPointer = true
+		t = t[1:]
+	}
+	if strings.Contains(t, "[") {
+		// the type arguments are named from the package of file.
+		t = instantiateAt(t, p, file)
+	}
+	if from, ok := pkgs[pkg].FieldsOf[p+"."+t]; ok && impl == nil {
+		tracePush(p + "." + t).set("field of " + from)
+		defer tracePop()
+		return fieldOf(from, t, pkg, isPointer)
+	}
+	s1, i := getStructOrInterface(t, p)
+	if impl != nil && i == nil {
+		s1 = impl
+	}
+	if s1 == nil && i == nil {
+		traceLeaf(localType(typ, file, pkg), "argument "+getVarName(name))
+		return getVarName(name), nil, []string{getVarName(name) + " " + localType(typ, file, pkg)}, localImports(typ, file, pkg), nil
+	}
+	if i != nil && impl == nil {
+		if _, ok := pkgs[pkg].Shared[localType(t, i.File, pkg)]; ok {
+			// shared values of interface types are used as they are.
+			s := &Struct{Name: i.Name, File: i.File, Underlying: "interface"}
+			code, args, imports, returns = generateFunctionBody(s, pkg, false, "")
+			return nodeRef(s, pkg, isPointer), code, args, imports, returns
+		}
+	}
+	if impl != nil && i != nil {
+		s1 = impl
+	} else if s1 == nil && i != nil {
+		d := strings.Title(os.Getenv("ENV")) + i.Name
+		dia, ok := diassignments[d]
+		if ok == false {
+			dia = diassignments[i.Name]
+		}
+		if dia == nil && pkgs[file.Package].external {
+			// interfaces used by external packages are arguments unless bound.
+			traceLeaf(localType(typ, file, pkg), "argument "+getVarName(name))
+			return getVarName(name), nil, []string{getVarName(name) + " " + localType(typ, file, pkg)}, localImports(typ, file, pkg), nil
+		}
+		if dia == nil && AutoBind {
+			dia = autoBind(i)
+		}
+		if dia == nil {
+			fmt.Println("Interface to Implementation not found for", i.Name)
+			os.Exit(1)
+		}
+		byPointer = dia.pointer
+		s1, _ = getStructOrInterface(dia.src, dia.pkg)
+		if s1 == nil && i != nil {
+			fmt.Println(dia.src + " not found")
+			os.Exit(1)
+		}
+	}
+	if i != nil && tracing {
+		how := "set to " + s1.Name + "{} in the declaration"
+		dia, ok := diassignments[strings.Title(os.Getenv("ENV"))+i.Name]
+		if !ok {
+			dia, ok = diassignments[i.Name]
+		}
+		if ok && impl == nil {
+			how = bindingHow(dia)
+		} else {
+			dia = nil
+		}
+		tracePush(i.File.Package + "." + i.Name).set(how).declared(dia)
+	}
+	iname := ""
+	if i != nil {
+		iname = getVarName(i.Name)
+		if impl != nil {
+			iname = getVarName(impl.Name) + identName(i.Name)
+		}
+		defer buildNode(iname)()
+	}
+	code, args, imports, returns = generateFunctionBody(s1, pkg, false, "")
+	if i != nil {
+		tracePop()
+	}
+
+	ref = nodeRef(s1, pkg, isPointer)
+	if i != nil {
+		pointer := implements(s1, i) || byPointer
+		it := i.Name
+		if i.File.Package != pkg {
+			it = i.File.Package + "." + i.Name
+			imports = append(imports, i.File.Path)
+		}
+		code = append(code, overrideNode(iname, it, pkg, iname+" = "+nodeRef(s1, pkg, pointer)))
+		ref = iname
+		if isPointer {
+			ref = "&" + iname
+		}
+	}
+	return
+}
+
+// getLiteralStruct returns the struct built by the empty composite literal
+// expr, as in the fields of a di.Build literal choosing the struct built for
+// a field.
+func getLiteralStruct(expr string, pkg string) *Struct {
+	expr = strings.TrimPrefix(expr, "&")
+	if !strings.HasSuffix(expr, "{}") {
+		return nil
+	}
+	name := strings.TrimSuffix(expr, "{}")
+	if strings.Contains(name, ".") {
+		pkg, name = strings.Split(name, ".")[0], strings.Split(name, ".")[1]
+	}
+	s, _ := getStructOrInterface(name, pkg)
+	return s
+}
+
+// fieldOf generates the code taking the value of type t from the field
+// declared with di.FieldsOf as pkg.Struct.Field in from.
+func fieldOf(from, t, pkg string, pointer bool) (ref string, code, args, imports, returns []string) {
+	f := strings.Split(from, ".")
+	parent, _ := getStructOrInterface(f[1], f[0])
+	typ := ""
+	for _, field := range parent.Fields {
+		if field.Name == f[2] {
+			typ = field.Type
+		}
+	}
+	build := "{VAR} = {VALUE}"
+	if typ[0:1] == "*" {
+		typ = typ[1:]
+		build = "if v := {VALUE}; v != nil {\n{VAR} = *v\n}"
+	}
+	name := getVarName(t)
+	if lt := localType(typ, parent.File, pkg); strings.Contains(lt, ".") {
+		// values of other packages are named after their package too.
+		name = getVarName(lt)
+	}
+	defer buildNode(name)()
+	code, args, imports, returns = generateFunctionBody(parent, pkg, false, "")
+	imports = append(imports, localImports(typ, parent.File, pkg)...)
+	typ = localType(typ, parent.File, pkg)
+	build = strings.ReplaceAll(build, "{VALUE}", strings.TrimPrefix(nodeRef(parent, pkg, true), "&")+"."+f[2])
+	build = strings.ReplaceAll(build, "{VAR}", name)
+	code = append(code, overrideNode(name, typ, pkg, build))
+	ref = name
+	if pointer {
+		ref = "&" + name
+	}
+	return
+}
+
+// variadicParam returns the type of the parameter typ as it is resolved,
+// []T for a variadic ...T, and what follows its argument in the call.
+func variadicParam(typ string) (t, spread string) {
+	if strings.HasPrefix(typ, "...") {
+		return "[]" + typ[3:], "..."
+	}
+	return typ, ""
+}
+
+// paramName returns the name of the argument passed as the parameter p of
+// the constructor of the value name. Variadic parameters, for e.g. opts, are
+// named after the value as in nameOpts so they do not clash.
+func paramName(name string, p *Type) string {
+	if !strings.HasPrefix(p.T, "...") {
+		return p.Name
+	}
+	if p.Name == "" || p.Name == "_" {
+		return name + "Args"
+	}
+	return name + strings.ToUpper(p.Name[0:1]) + p.Name[1:]
+}
+
+// constructorCall assigns the result of call, a handwritten constructor
+// returning results, to the variable name, taking the address of the result
+// when pointer is set.
+func constructorCall(results []*Type, call, name string, pointer bool) (c string, hasErr bool) {
+	var vars []string
+	tmp, conv := name, ""
+	for i, v := range results {
+		if v.T == "error" {
+			vars = append(vars, "err")
+			hasErr = true
+			continue
+		}
+		if i > 0 {
+			vars = append(vars, "_")
+			continue
+		}
+		if v.T[0:1] == "*" && !pointer {
+			tmp = "_" + name
+			conv = name + " = *" + tmp
+		} else if v.T[0:1] != "*" && pointer {
+			tmp = "ret" + name
+			conv = name + " = &" + tmp
+		}
+		vars = append(vars, tmp)
+	}
+	if tmp != name {
+		c = "var " + tmp + " " + results[0].T + "\n"
+	}
+	c += strings.Join(vars, ", ") + " = " + call
+	if hasErr {
+		c += "\nif err != nil {\nreturn\n}"
+	}
+	if conv != "" {
+		c += "\n" + conv
+	}
+	return
+}
+
+// overrideNode declares the variable name of type typ and wraps the code
+// building it, so a value passed through the With<Name> option is used instead
+// and the values only it uses are not built.
+func overrideNode(name, typ, pkg, build string) string {
+	pkgs[pkg].Options[name] = typ
+	overridable[name] = true
+	return "var " + name + " " + typ + "\nif diO." + name + " != nil {\n" + name + " = *diO." + name + "\n} else if " + buildVar(name) + " {\n" + build + "\n}"
+}
+
+// overrideShared points _name at the shared value name using get unless an
+// option replaces it, so the shared value itself is never reassigned.
+func overrideShared(name, typ, pkg, get string) string {
+	pkgs[pkg].Options[name] = typ
+	overridable[name] = true
+	return "var _" + name + " *" + typ + "\nif diO." + name + " != nil {\n_" + name + " = diO." + name + "\n} else if " + buildVar(name) + " {\n" + get + "\n}"
+}
+
+// nodeName returns the name of the variable holding the built value of s,
+// values shared or built from other modules are named after their package too.
+func nodeName(s *Struct, pkg string) string {
+	typ := typeIn(s, pkg)
+	if _, ok := pkgs[pkg].Shared[typ]; ok || pkgs[s.File.Package].external {
+		return getVarName(typ)
+	}
+	return getVarName(unqualify(s.Name, pkg))
+}
+
+// nodeRef returns the expression used to pass the built value of s to a
+// struct field, or its address when pointer is set.
+func nodeRef(s *Struct, pkg string, pointer bool) string {
+	name := nodeName(s, pkg)
+	co, ok := pkgs[pkg].Shared[typeIn(s, pkg)]
+	if (ok && (co == name || sharedRef(co, pkg) != "")) || (!ok && pkgs[s.File.Package].external && s.Underlying == "") {
+		name = "_" + name
+		if pointer {
+			return name
+		}
+		return "*" + name
+	}
+	if pointer {
+		return "&" + name
+	}
+	return name
+}
+
+// isNamedType reports whether typ, without its pointers and type arguments,
+// is a type name such as Port or time.Duration.
+func isNamedType(typ string) bool {
+	t := baseName(strings.TrimLeft(typ, "*"))
+	if i := strings.Index(t, "."); i >= 0 {
+		return token.IsIdentifier(t[:i]) && token.IsIdentifier(t[i+1:])
+	}
+	return token.IsIdentifier(t)
+}
+
+// typeImports returns the import paths of the packages used in typ.
+func typeImports(typ string, file *CodeFile) (imports []string) {
+	expr, err := parser.ParseExpr(strings.TrimPrefix(typ, "..."))
+	if err != nil {
+		return
+	}
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && file.Imports[x.Name] != "" {
+				imports = append(imports, file.Imports[x.Name])
+			}
+		}
+		return true
+	})
+	return
+}
+
+// sharedRef returns the pointer expression of a shared value that refers to
+// a package variable, as in di.Share(db, &db), or "" when it is a copy.
+func sharedRef(co, pkg string) string {
+	if strings.HasPrefix(co, "&") && token.IsIdentifier(co[1:]) {
+		return co
+	}
+	if t := pkgs[pkg].Vars[co]; strings.HasPrefix(t, "*") {
+		return co
+	}
+	return ""
+}
+
 func getStructOrInterface(s string, p string) (*Struct, *Interface) {
 	pkg, ok := pkgs[p]
 	if ok {
@@ -554,6 +1579,9 @@ func getStructOrInterface(s string, p string) (*Struct, *Interface) {
 				return nil, st
 			}
 		}
+		if strings.Contains(s, "[") {
+			return instantiate(pkg, s)
+		}
 	}
 
 	return nil, nil
@@ -564,7 +1592,7 @@ func getStructMethod(s string, method string, packageName string) *Method {
 	if ok {
 		for _, v := range pkg.Methods {
 			if v.Name == method && v.Reciever != nil {
-				recv := v.Reciever.T
+				recv, s := baseName(v.Reciever.T), baseName(s)
 				if recv[0:1] == "*" && recv[1:] == s {
 					return v
 				} else if recv == s {
@@ -576,6 +1604,27 @@ func getStructMethod(s string, method string, packageName string) *Method {
 	return nil
 }
 
+// getProvider returns the function called by the provider code of a shared
+// value, which can be qualified by the name of an imported package.
+func getProvider(code, pkg string) *Method {
+	name := strings.Split(code, "(")[0]
+	if i := strings.Index(name, "."); i >= 0 {
+		pkg, name = name[:i], name[i+1:]
+	}
+	return getMethod(name, pkg)
+}
+
+// getConstructor returns the function building s, the one having a
+// //di:provide directive returning s or else New<Name>.
+func getConstructor(s *Struct) *Method {
+	for _, m := range pkgs[s.File.Package].Methods {
+		if m.Provide && m.Reciever == nil && len(m.Results) > 0 && strings.TrimPrefix(m.Results[0].T, "*") == s.Name {
+			return m
+		}
+	}
+	return getMethod("New"+identName(s.Name), s.File.Package)
+}
+
 func getMethod(method string, packageName string) *Method {
 	pkg, ok := pkgs[packageName]
 	if ok {
@@ -589,27 +1638,35 @@ func getMethod(method string, packageName string) *Method {
 }
 
 // traverse dir traverses given directory recursively and parse go files
-func traversDir(dir string) (diFiles []*ast.File, otherFiles []*CodeFile) {
+func traversDir(dir string) (diFiles []*ast.File, otherFiles []*CodeFile, testFiles []*ast.File) {
 	files, _ := os.ReadDir(dir)
 	for _, file := range files {
 		if file.IsDir() {
 
-			if ContainsStr([]string{"vendor", ".git"}, file.Name()) == false {
-				dif, otf := traversDir(dir + "/" + file.Name())
+			if skipDir(dir, file.Name()) == false {
+				dif, otf, tf := traversDir(dir + "/" + file.Name())
 				diFiles = append(diFiles, dif...)
 				otherFiles = append(otherFiles, otf...)
+				testFiles = append(testFiles, tf...)
 			} else {
 				logMsg("[Parser] Skipped " + dir + "/" + file.Name())
 
 			}
 		} else {
-			switch file.Name() {
-			case "di_gen.go":
-			case "di.go":
-				diFiles = append(diFiles, parseFile(dir+"/"+file.Name()))
-				logMsg("[Parser] Parsed " + dir + "/" + file.Name())
+			name := file.Name()
+			switch {
+			case name == Output || name == testOutput():
+			case name == "di.go" || name == "di_test.go" || IsDeclFile(dir+"/"+name):
+				f := parseFile(dir + "/" + name)
+				if strings.HasSuffix(name, "_test.go") {
+					testFiles = append(testFiles, f)
+					testPkgs[strings.TrimSuffix(f.Name.Name, "_test")] = true
+				} else {
+					diFiles = append(diFiles, f)
+				}
+				logMsg("[Parser] Parsed " + dir + "/" + name)
 			default:
-				if strings.LastIndex(file.Name(), ".go") > 0 {
+				if strings.HasSuffix(file.Name(), ".go") && matchFile(dir, file.Name()) {
 					otherFiles = append(otherFiles, parseGoFile(dir+"/"+file.Name()))
 					logMsg("[Parser] Parsed " + dir + "/" + file.Name())
 				}
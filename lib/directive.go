@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// addDirectives adds to diassignments the dependencies declared by the //di:
+// comments of files. The ones declared in a di.go with the same key are kept
+// and only the directives with the key of another directive are duplicates.
+func addDirectives(files []*CodeFile) {
+	for _, file := range files {
+		if file == nil {
+			continue
+		}
+		for _, dir := range file.Directives {
+			d := directiveDi(file, dir)
+			if d == nil {
+				continue
+			}
+			k := d.src
+			if d.inter != "" {
+				k = strings.Title(d.env) + d.inter
+			}
+			if prev, ok := diassignments[k]; ok {
+				if prev.directive != "" {
+					// declaration files override directives on purpose, two directives clash.
+					duplicates = append(duplicates, describe(prev)+" replaces "+describe(d))
+				}
+				continue
+			}
+			diassignments[k] = d
+		}
+	}
+}
+
+// directiveDi returns the declaration made by the directive dir of file, nil
+// for //di:provide which only marks the function.
+func directiveDi(file *CodeFile, dir *Directive) *Di {
+	d := &Di{method: "Build", src: dir.Target, pkg: file.Package, aliases: file.Imports, pos: dir.Pos, directive: "//di:" + dir.Name}
+	switch {
+	case dir.Name == "build" && dir.Kind == "type" && len(dir.Args) == 0:
+	case dir.Name == "bind" && dir.Kind == "type" && (len(dir.Args) == 1 || len(dir.Args) == 2):
+		d.method, d.inter, d.interPkg = "Bind", dir.Args[0], file.Package
+		if i := strings.Index(d.inter, "."); i >= 0 {
+			d.interPkg, d.inter = d.inter[:i], d.inter[i+1:]
+		}
+		if len(dir.Args) == 2 {
+			d.method, d.env = "BindEnv", dir.Args[1]
+		}
+	case dir.Name == "provide" && dir.Kind == "func" && len(dir.Args) == 0:
+		return nil
+	case dir.Name == "shared" && dir.Kind == "var" && len(dir.Args) == 0:
+		if _, ok := file.Vars[dir.Target]; !ok {
+			fmt.Println("//di:shared is only allowed on package variables, " + dir.Target + " is not one in package " + file.Package)
+			os.Exit(1)
+		}
+		// the type of the variable is resolved from its value by generate.
+		t := pkgs[file.Package].Vars[dir.Target]
+		d.method, d.code = "Share", dir.Target
+		d.src, d.pointer = strings.TrimPrefix(t, "*"), strings.HasPrefix(t, "*")
+	default:
+		fmt.Println("invalid directive //di:" + strings.Join(append([]string{dir.Name}, dir.Args...), " ") + " on " + dir.Kind + " " + dir.Target + " in package " + file.Package)
+		os.Exit(1)
+	}
+	return d
+}
@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// traceNode is a value resolved while a constructor is generated, how tells
+// where it comes from.
+type traceNode struct {
+	typ      string
+	how      string
+	children []*traceNode
+	// decl is the declaration used for the node, nil when there is none.
+	decl *Di
+}
+
+// tracing makes the generation record in traces how every constructor
+// resolves its dependencies instead of writing the generated files.
+var tracing bool
+
+// traces holds the resolution of the constructors by their qualified type,
+// for e.g. main.UserHandler.
+var traces map[string]*traceNode
+
+var traceStack []*traceNode
+
+// tracePush adds a node for typ under the node being resolved and makes it
+// the node being resolved until tracePop.
+func tracePush(typ string) *traceNode {
+	if !tracing {
+		return nil
+	}
+	n := &traceNode{typ: typ}
+	if len(traceStack) > 0 {
+		top := traceStack[len(traceStack)-1]
+		top.children = append(top.children, n)
+	}
+	traceStack = append(traceStack, n)
+	return n
+}
+
+func tracePop() {
+	if tracing {
+		traceStack = traceStack[:len(traceStack)-1]
+	}
+}
+
+// traceLeaf adds a node without dependencies under the node being resolved.
+func traceLeaf(typ, how string) {
+	tracePush(typ).set(how)
+	tracePop()
+}
+
+func (n *traceNode) set(how string) *traceNode {
+	if n != nil {
+		n.how = how
+	}
+	return n
+}
+
+// declared records that the node is resolved with the declaration d.
+func (n *traceNode) declared(d *Di) {
+	if n != nil {
+		n.decl = d
+	}
+}
+
+// traceRoot starts the resolution of the constructor declared by d, the
+// returned function ends it and records it in traces.
+func traceRoot(d *Di) func() {
+	if !tracing {
+		return func() {}
+	}
+	key := d.pkg + "." + d.src
+	if d.inter != "" {
+		ip := d.interPkg
+		if ip == "" {
+			ip = d.pkg
+		}
+		key = ip + "." + d.inter
+	}
+	stack := traceStack
+	root := &traceNode{typ: key, how: describe(d), decl: d}
+	traceStack = []*traceNode{root}
+	return func() {
+		if len(root.children) == 1 && root.children[0].typ == key {
+			// the struct built by di.Build is the root itself.
+			root.how += ", " + root.children[0].how
+			root.children = root.children[0].children
+		}
+		traces[key] = root
+		traceStack = stack
+	}
+}
+
+// describe returns the declaration d as it is written and its position.
+func describe(d *Di) string {
+	decl := "di." + d.method
+	if d.directive != "" {
+		decl = d.directive
+	}
+	if d.method == "BindEnv" {
+		decl += " for ENV=" + d.env
+	}
+	if d.pos != "" {
+		decl += " at " + d.pos
+	}
+	return decl
+}
+
+// bindingHow returns how an interface is bound by dia, the ENV variable
+// chooses between di.BindEnv and di.Bind.
+func bindingHow(dia *Di) string {
+	how := "bound to " + dia.src + " by " + describe(dia)
+	if env := os.Getenv("ENV"); env != "" && dia.method != "BindEnv" {
+		how += ", no di.BindEnv for ENV=" + env
+	}
+	return how
+}
+
+// Explain prints how the constructor of typ, for e.g. main.UserHandler,
+// resolves every dependency.
+func Explain(dir, mod, typ string) {
+	trace(dir, mod)
+	var keys []string
+	for _, k := range traceKeys() {
+		if k == typ || strings.HasSuffix(k, "."+typ) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Println("no constructor is declared for " + typ)
+		os.Exit(1)
+	}
+	for _, k := range keys {
+		printTrace(traces[k], "")
+	}
+}
+
+// Why prints every path from a constructor to the dependency typ, for e.g.
+// main.Db.
+func Why(dir, mod, typ string) {
+	trace(dir, mod)
+	found := false
+	for _, k := range traceKeys() {
+		var walk func(n *traceNode, path []string)
+		walk = func(n *traceNode, path []string) {
+			path = append(path, n.typ)
+			if len(path) > 1 && (n.typ == typ || strings.HasSuffix(n.typ, "."+typ)) {
+				fmt.Println(strings.Join(path, " > ") + ": " + n.how)
+				found = true
+			}
+			for _, c := range n.children {
+				walk(c, path)
+			}
+		}
+		walk(traces[k], nil)
+	}
+	if !found {
+		fmt.Println("no constructor uses " + typ)
+		os.Exit(1)
+	}
+}
+
+func trace(dir, mod string) {
+	setup(dir, mod)
+	tracing, traces = true, make(map[string]*traceNode)
+	generate(dir)
+}
+
+func traceKeys() []string {
+	var keys []string
+	for k := range traces {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printTrace(n *traceNode, indent string) {
+	fmt.Println(indent + n.typ + ": " + n.how)
+	for _, c := range n.children {
+		printTrace(c, indent+"  ")
+	}
+}
@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// modulePath and moduleRoot are the path and the directory of the module
+// being generated and scanDir the directory being scanned, the packages in
+// it are parsed already and never loaded as external packages.
+var modulePath, moduleRoot, scanDir string
+
+// loaded maps the import paths of the loaded external packages to their
+// package names, "" when they could not be loaded.
+var loaded map[string]string
+
+// loadPackage parses the package imported as path from GOROOT, the vendor
+// directory or the module cache and adds it to pkgs. It returns the name of
+// the package or "" when it is part of the module or can not be found.
+func loadPackage(path string) string {
+	if n, ok := loaded[path]; ok {
+		return n
+	}
+	loaded[path] = ""
+	dir := packageDir(path)
+	if dir == "" {
+		logMsg("[Parser] Package " + path + " not found")
+		return ""
+	}
+	if dir == scanDir || strings.HasPrefix(dir, scanDir+string(filepath.Separator)) {
+		return ""
+	}
+	entries, _ := os.ReadDir(dir)
+	var files []*CodeFile
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() || !strings.HasSuffix(n, ".go") || strings.HasSuffix(n, "_test.go") {
+			continue
+		}
+		if ok, _ := BuildContext.MatchFile(dir, n); !ok {
+			continue
+		}
+		if f := parseGoFile(filepath.Join(dir, n)); f != nil {
+			f.Path = path
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return ""
+	}
+	name := files[0].Package
+	if other, ok := pkgs[name]; ok {
+		// packages are held by name, the one parsed first is kept.
+		from := other.path
+		if !other.external {
+			from = filepath.Dir(from)
+		}
+		fmt.Println("warning: " + path + " is not loaded as package " + name + " is already parsed from " + from + ", its types are taken as arguments")
+		return ""
+	}
+	pkg := newPackage(name, path)
+	pkg.external = true
+	for _, f := range files {
+		if f.Package != name {
+			continue
+		}
+		pkg.Structs = append(pkg.Structs, f.Structs...)
+		pkg.Methods = append(pkg.Methods, f.Methods...)
+		pkg.Interfaces = append(pkg.Interfaces, f.Interfaces...)
+	}
+	pkgs[name] = pkg
+	loaded[path] = name
+	logMsg("[Parser] Loaded " + path + " from " + dir)
+	return name
+}
+
+// packageName returns the name of the package imported as alias, loading it
+// when it is an external package.
+func packageName(alias string, aliases map[string]string) string {
+	if _, ok := pkgs[alias]; ok || aliases[alias] == "" {
+		return alias
+	}
+	if n := loadPackage(aliases[alias]); n != "" {
+		return n
+	}
+	return alias
+}
+
+// packageDir returns the directory of the package imported as path.
+func packageDir(path string) string {
+	goroot := goEnv("GOROOT")
+	if !strings.Contains(strings.Split(path, "/")[0], ".") {
+		return existingDir(filepath.Join(goroot, "src", path))
+	}
+	if dir := workspaceDir(path); dir != "" {
+		return dir
+	}
+	if dir := existingDir(filepath.Join(moduleRoot, "vendor", path)); dir != "" && moduleRoot != "" {
+		return dir
+	}
+	var mod module.Version
+	var replaces []*modfile.Replace
+	var replaceDirs []string
+	for _, root := range workspace {
+		data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+		if err != nil {
+			continue
+		}
+		mf, err := modfile.Parse("go.mod", data, nil)
+		if err != nil {
+			continue
+		}
+		for _, r := range mf.Require {
+			if (path == r.Mod.Path || strings.HasPrefix(path, r.Mod.Path+"/")) && len(r.Mod.Path) > len(mod.Path) {
+				mod = r.Mod
+			}
+		}
+		for _, r := range mf.Replace {
+			replaces = append(replaces, r)
+			replaceDirs = append(replaceDirs, root)
+		}
+	}
+	if mod.Path == "" {
+		return existingDir(filepath.Join(goroot, "src", "vendor", path))
+	}
+	rest := strings.TrimPrefix(path[len(mod.Path):], "/")
+	// the replace directives of go.work come last so they are applied last.
+	for _, r := range workReplaces {
+		replaces = append(replaces, r)
+		replaceDirs = append(replaceDirs, filepath.Dir(findWorkFile(scanDir)))
+	}
+	var replaced *modfile.Replace
+	var replacedIn string
+	for i, r := range replaces {
+		if r.Old.Path == mod.Path && (r.Old.Version == "" || r.Old.Version == mod.Version) {
+			replaced, replacedIn = r, replaceDirs[i]
+		}
+	}
+	if replaced != nil && replaced.New.Version == "" {
+		// replaced by a local directory
+		dir := replaced.New.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(replacedIn, dir)
+		}
+		return existingDir(filepath.Join(dir, rest))
+	} else if replaced != nil {
+		mod = replaced.New
+	}
+	p, err := module.EscapePath(mod.Path)
+	if err != nil {
+		return ""
+	}
+	v, err := module.EscapeVersion(mod.Version)
+	if err != nil {
+		return ""
+	}
+	return existingDir(filepath.Join(goEnv("GOMODCACHE"), p+"@"+v, rest))
+}
+
+func existingDir(dir string) string {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return ""
+}
+
+var goEnvs = make(map[string]string)
+
+// goEnv returns the value of the go environment variable name.
+func goEnv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	if v, ok := goEnvs[name]; ok {
+		return v
+	}
+	out, _ := exec.Command("go", "env", name).Output()
+	goEnvs[name] = strings.TrimSpace(string(out))
+	return goEnvs[name]
+}
+
+// typeIn returns the type of s as written in the package pkg.
+func typeIn(s *Struct, pkg string) string {
+	if s.File.Package != pkg && len(s.TypeArgs) > 0 {
+		// the type arguments are qualified too, for e.g. repo.Repo[repo.Item].
+		return localType(s.Name, s.File, pkg)
+	}
+	if s.File.Package != pkg {
+		return s.File.Package + "." + s.Name
+	}
+	return s.Name
+}
+
+// localType returns typ, written in file, as written in the package pkg.
+func localType(typ string, file *CodeFile, pkg string) string {
+	if file.Package == pkg {
+		return typ
+	}
+	return unqualify(qualifyType(typ, file), pkg)
+}
+
+// unqualify removes the package name pkg from the types of pkg used in typ,
+// for e.g. repo.Repo[app.User] is repo.Repo[User] in the package app.
+func unqualify(typ, pkg string) string {
+	if !strings.Contains(typ, pkg+".") {
+		return typ
+	}
+	return regexp.MustCompile(`(^|[^\w.])`+regexp.QuoteMeta(pkg)+`\.`).ReplaceAllString(typ, "$1")
+}
+
+// localImports returns the imports needed to write typ, written in file, in
+// the package pkg.
+func localImports(typ string, file *CodeFile, pkg string) []string {
+	imports := typeImports(typ, file)
+	if file.Package != pkg && strings.Contains(localType(typ, file, pkg), file.Package+".") {
+		imports = append(imports, file.Path)
+	}
+	return imports
+}
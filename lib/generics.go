@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strings"
+	"unicode"
+)
+
+// typeArgs splits an instantiated type such as Cache[string, int] into the
+// generic type and its type arguments.
+func typeArgs(t string) (base string, args []string) {
+	expr, err := parser.ParseExpr(t)
+	if err != nil {
+		return t, nil
+	}
+	var indices []ast.Expr
+	switch e := expr.(type) {
+	case *ast.IndexExpr:
+		expr, indices = e.X, []ast.Expr{e.Index}
+	case *ast.IndexListExpr:
+		expr, indices = e.X, e.Indices
+	default:
+		return t, nil
+	}
+	for _, i := range indices {
+		args = append(args, exprString(i))
+	}
+	return exprString(expr), args
+}
+
+func exprString(e ast.Expr) string {
+	buf := new(strings.Builder)
+	printer.Fprint(buf, token.NewFileSet(), e)
+	return buf.String()
+}
+
+// substitute replaces the type parameters params used in t by args.
+func substitute(t string, params, args []string) string {
+	if len(params) == 0 || len(params) != len(args) {
+		return t
+	}
+	variadic := strings.HasPrefix(t, "...")
+	expr, err := parser.ParseExpr(strings.TrimPrefix(t, "..."))
+	if err != nil {
+		return t
+	}
+	var replace func(n ast.Node) bool
+	replace = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Field:
+			ast.Inspect(n.Type, replace)
+			return false
+		case *ast.SelectorExpr:
+			return false
+		case *ast.Ident:
+			for i, p := range params {
+				if n.Name == p {
+					n.Name = args[i]
+				}
+			}
+		}
+		return true
+	}
+	ast.Inspect(expr, replace)
+	if variadic {
+		return "..." + exprString(expr)
+	}
+	return exprString(expr)
+}
+
+// identName returns the name used in identifiers for the type t, for e.g.
+// RepoUser for Repo[User], CacheStringInt for Cache[string, int] and
+// MapStringRoute for map[string]Route.
+func identName(t string) string {
+	if token.IsIdentifier(t) {
+		return t
+	}
+	t = strings.ReplaceAll(t, "[]", " slice ")
+	var b strings.Builder
+	for _, w := range strings.FieldsFunc(t, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		b.WriteString(strings.ToUpper(w[0:1]) + w[1:])
+	}
+	return b.String()
+}
+
+// baseName strips the type parameters or arguments from the type t.
+func baseName(t string) string {
+	if i := strings.Index(t, "["); i >= 0 {
+		return t[:i]
+	}
+	return t
+}
+
+// instantiateAt returns the instantiation t of a generic type of the package
+// p as named in p, with its type arguments written in file qualified by their
+// package, for e.g. Repo[app.User] for repo.Repo[User] used in package app.
+// Its fields then name the packages of the type arguments as file does.
+func instantiateAt(t, p string, file *CodeFile) string {
+	base, args := typeArgs(t)
+	if len(args) == 0 || file.Package == p {
+		return t
+	}
+	// the import paths of the packages qualifying the type arguments, the
+	// package of file does not import itself.
+	paths := make(map[string]string)
+	for _, path := range file.Imports {
+		n := importName(path)
+		if l := loaded[path]; l != "" {
+			n = l
+		}
+		paths[n] = path
+	}
+	for i, a := range args {
+		args[i] = unqualify(qualifyType(a, file), p)
+	}
+	t = base + "[" + strings.Join(args, ", ") + "]"
+	s, _ := getStructOrInterface(t, p)
+	if s == nil {
+		return t
+	}
+	for n, path := range paths {
+		if s.File.Imports[n] != "" || !strings.Contains(strings.Join(args, ","), n+".") {
+			continue
+		}
+		// the file of s is copied as it is shared with the generic type.
+		f := *s.File
+		f.Imports = map[string]string{n: path}
+		for k, v := range s.File.Imports {
+			f.Imports[k] = v
+		}
+		s.File = &f
+	}
+	return t
+}
+
+// instantiatedAt reports whether the type arguments of s name types of other
+// packages, the package using s then builds it.
+func instantiatedAt(s *Struct) bool {
+	for _, a := range s.TypeArgs {
+		if strings.Contains(a, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// instantiate adds to pkg the struct or interface t, an instantiation of one
+// of its generic types, with the type arguments substituted in its fields
+// and methods.
+func instantiate(pkg Package, t string) (*Struct, *Interface) {
+	base, args := typeArgs(t)
+	if len(args) == 0 {
+		return nil, nil
+	}
+	for _, g := range pkg.Structs {
+		if g.Name != base || len(g.TypeParams) != len(args) {
+			continue
+		}
+		s := &Struct{Name: t, File: g.File, TypeArgs: args}
+		if g.Underlying != "" {
+			s.Underlying = substitute(g.Underlying, g.TypeParams, args)
+		}
+		for _, f := range g.Fields {
+			s.Fields = append(s.Fields, &Field{Struct: s, Name: f.Name, Type: substitute(f.Type, g.TypeParams, args), Tag: f.Tag, Embedded: f.Embedded})
+		}
+		pkg.Structs = append(pkg.Structs, s)
+		pkgs[pkg.name] = pkg
+		return s, nil
+	}
+	for _, g := range pkg.Interfaces {
+		if g.Name != base || len(g.TypeParams) != len(args) {
+			continue
+		}
+		i := &Interface{Name: t, File: g.File, Embeds: g.Embeds}
+		for _, m := range g.Methods {
+			i.Methods = append(i.Methods, instantiateMethod(m, g.TypeParams, args))
+		}
+		pkg.Interfaces = append(pkg.Interfaces, i)
+		pkgs[pkg.name] = pkg
+		return nil, i
+	}
+	return nil, nil
+}
+
+// instantiateMethod returns a copy of m with the type parameters params
+// replaced by args in its parameters and results.
+func instantiateMethod(m *Method, params, args []string) *Method {
+	c := *m
+	c.Params, c.Results = nil, nil
+	for _, t := range m.Params {
+		c.Params = append(c.Params, &Type{Name: t.Name, T: substitute(t.T, params, args)})
+	}
+	for _, t := range m.Results {
+		c.Results = append(c.Results, &Type{Name: t.Name, T: substitute(t.T, params, args)})
+	}
+	return &c
+}
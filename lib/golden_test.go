@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"bytes"
+	"flag"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata.")
+
+func TestMain(m *testing.M) {
+	// the generation exits on errors, the golden tests run it in a child
+	// process started with DI_GOLDEN_DIR.
+	if dir := os.Getenv("DI_GOLDEN_DIR"); dir != "" {
+		if os.Getenv("DI_GOLDEN_CMD") == "lint" {
+			Lint(dir, "")
+		} else {
+			Run(dir, "")
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// generateGolden copies the module testdata/name to a temporary directory,
+// runs cmd on it and returns the directory and what it printed, ended by its
+// exit status, followed by the generated files.
+func generateGolden(t *testing.T, name, cmd string) (dir string, out string, ok bool) {
+	dir = t.TempDir()
+	src := filepath.Join("testdata", name)
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0755)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, rel), b, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := exec.Command(os.Args[0])
+	c.Env = append(os.Environ(), "DI_GOLDEN_DIR="+dir, "DI_GOLDEN_CMD="+cmd)
+	b, err := c.CombinedOutput()
+	ok = err == nil
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	out = "-- output --\n" + strings.ReplaceAll(string(b), dir, "$DIR") + status + "\n"
+	var generated []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && (d.Name() == Output || d.Name() == testOutput()) {
+			generated = append(generated, path)
+		}
+		return nil
+	})
+	sort.Strings(generated)
+	for _, path := range generated {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(dir, path)
+		out += "-- " + filepath.ToSlash(rel) + " --\n" + string(b)
+	}
+	return
+}
+
+// goCommand runs the go command args in dir, outside of any workspace.
+func goCommand(t *testing.T, dir string, args ...string) {
+	c := exec.Command("go", args...)
+	c.Dir = dir
+	c.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	if b, err := c.CombinedOutput(); err != nil {
+		t.Errorf("go %s: %v\n%s", strings.Join(args, " "), err, b)
+	}
+}
+
+// TestGolden generates the modules of testdata, compares the output with
+// their .golden file and checks the generated code with go vet and their
+// tests. -update rewrites the .golden files.
+func TestGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cmd  string
+	}{
+		// overridden values do not build their dependencies and the
+		// generated options do not clash with the arguments.
+		{"override", ""},
+		// variadic parameters are named after the value they build.
+		{"variadic", ""},
+		// the methods promoted from embedded fields implement interfaces.
+		{"embedded", ""},
+		// generic types instantiated with the types of the package using
+		// them are built by it.
+		{"generics", ""},
+		// di_test.go can not use the types of the external test package.
+		{"externaltest", ""},
+		// di.go and di_test.go override the directives on purpose.
+		{"overrides", "lint"},
+		// the default of a slice field has to be the last option.
+		{"defaults", ""},
+		{"defaultlast", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, got, ok := generateGolden(t, tc.name, tc.cmd)
+			golden := filepath.Join("testdata", tc.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(want, []byte(got)) {
+				t.Errorf("generated\n%s\nwant\n%s", got, want)
+			}
+			if ok && tc.cmd == "" {
+				goCommand(t, dir, "vet", "./...")
+				goCommand(t, dir, "test", "./...")
+			}
+		})
+	}
+}
@@ -1,9 +1,7 @@
 package lib
 
 import (
-	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strings"
 )
@@ -20,30 +18,30 @@ func removeDuplicateStr(strSlice []string) []string {
 	return list
 }
 
+// importName returns the name a package imported as path is referred by
+// when it is imported without a name, for e.g. yaml for gopkg.in/yaml.v3
+// and client for example.com/client/v2.
+func importName(path string) string {
+	parts := strings.Split(path, "/")
+	name := parts[len(parts)-1]
+	if len(parts) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = parts[len(parts)-2]
+	}
+	if i := strings.Index(name, ".v"); i > 0 && strings.Trim(name[i+2:], "0123456789") == "" {
+		name = name[:i]
+	}
+	return name
+}
+
 func getVarName(name string) string {
 	if name[0:1] == "*" {
 		name = name[1:]
 
 	}
+	name = identName(name)
 	return strings.ToLower(name[0:1]) + name[1:]
 }
 
-func getCurrentModuleName() string {
-	dir, _ := os.Getwd()
-	gomodfile := dir + "/" + "go.mod"
-	if _, err := os.Stat(gomodfile); errors.Is(err, os.ErrNotExist) {
-		fmt.Println("go module does not exists")
-
-	}
-	gmodfile, err := os.ReadFile(gomodfile)
-	if err != nil {
-		log.Panic("go module file is not readable")
-	}
-	modline := strings.Split(string(gmodfile), "\n")[0]
-	return strings.ReplaceAll(modline, "module ", "")
-
-}
-
 func WriteFile(fp string, b []byte) {
 	fmt.Println("GENERATED CODE FOR", fp)
 
@@ -0,0 +1,286 @@
+package lib
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+)
+
+// implements checks that s implements every method of i and reports whether
+// only *s does, as some of the methods have pointer receivers.
+func implements(s *Struct, i *Interface) (pointer bool) {
+	problems, pointer := missingMethods(s, i)
+	if len(problems) > 0 {
+		fmt.Println("struct " + s.Name + " does not implement " + i.Name + ":\n\t" + strings.Join(problems, "\n\t"))
+		os.Exit(1)
+	}
+	return
+}
+
+// missingMethods returns the methods of i which s does not implement and
+// whether only *s implements the other ones.
+func missingMethods(s *Struct, i *Interface) (problems []string, pointer bool) {
+	for _, im := range interfaceMethods(i, nil) {
+		want := signature(im)
+		m, byPointer, _ := findMethod(s, im.Name, map[*Struct]bool{})
+		if m == nil {
+			problems = append(problems, "missing method "+im.Name+want)
+			continue
+		}
+		if got := signature(m); got != want {
+			problems = append(problems, "method "+im.Name+got+" should be "+im.Name+want)
+			continue
+		}
+		pointer = pointer || byPointer
+	}
+	return
+}
+
+// findMethod returns the method name of s, declared by s or promoted from its
+// embedded fields, whether only *s has it and how deep it is embedded. As in
+// Go the shallowest method is used and the ambiguous ones are not found.
+func findMethod(s *Struct, name string, path map[*Struct]bool) (m *Method, pointer bool, depth int) {
+	if m = getStructMethod(s.Name, name, s.File.Package); m != nil {
+		if len(s.TypeArgs) > 0 {
+			_, params := typeArgs(strings.TrimPrefix(m.Reciever.T, "*"))
+			m = instantiateMethod(m, params, s.TypeArgs)
+		}
+		return m, m.Reciever.T[0:1] == "*", 0
+	}
+	if path[s] {
+		return nil, false, 0
+	}
+	path[s] = true
+	defer delete(path, s)
+	ambiguous := false
+	for _, f := range s.Fields {
+		if !f.Embedded {
+			continue
+		}
+		p, t := s.File.Package, strings.TrimPrefix(f.Type, "*")
+		if i := strings.Index(t, "."); i >= 0 {
+			p, t = t[:i], t[i+1:]
+			if imp, ok := s.File.Imports[p]; ok {
+				if n := loadPackage(imp); n != "" {
+					p = n
+				}
+			}
+		}
+		var fm *Method
+		fp, d := false, 1
+		if e, i := getStructOrInterface(t, p); i != nil {
+			for _, im := range interfaceMethods(i, nil) {
+				if im.Name == name {
+					fm = im
+				}
+			}
+		} else if e != nil {
+			fm, fp, d = findMethod(e, name, path)
+			d++
+		}
+		if fm == nil {
+			continue
+		}
+		if f.Type[0:1] == "*" {
+			// the methods of *T are promoted to s when it embeds *T.
+			fp = false
+		}
+		if m == nil || d < depth {
+			m, pointer, depth, ambiguous = fm, fp, d, false
+		} else if d == depth {
+			ambiguous = true
+		}
+	}
+	if ambiguous {
+		return nil, false, 0
+	}
+	return
+}
+
+// AutoBind makes the interfaces which are not bound use their only
+// implementation in the scanned packages.
+var AutoBind bool
+
+// autoBind returns the binding of i to its only implementation in the
+// scanned packages, it fails when there are several ones.
+func autoBind(i *Interface) *Di {
+	var found []*Di
+	var names []string
+	for _, pkg := range pkgs {
+		if pkg.external {
+			continue
+		}
+		for _, s := range pkg.Structs {
+			if len(s.TypeParams) > 0 || s.Underlying == "interface" {
+				continue
+			}
+			if problems, pointer := missingMethods(s, i); len(problems) == 0 {
+				found = append(found, &Di{method: "Bind", inter: i.Name, interPkg: i.File.Package, src: s.Name, pkg: s.File.Package, pointer: pointer, directive: "--autobind"})
+				names = append(names, s.File.Package+"."+s.Name)
+			}
+		}
+	}
+	if len(found) > 1 {
+		sort.Strings(names)
+		fmt.Println("Interface " + i.Name + " is not bound and has several implementations: " + strings.Join(names, ", "))
+		os.Exit(1)
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	logMsg("[AutoBind] Bound " + i.Name + " to " + names[0])
+	diassignments[i.Name] = found[0]
+	return found[0]
+}
+
+// interfaceMethods returns the methods of i including the ones of the
+// interfaces it embeds.
+func interfaceMethods(i *Interface, seen map[*Interface]bool) []*Method {
+	if seen == nil {
+		seen = make(map[*Interface]bool)
+	}
+	if seen[i] {
+		return nil
+	}
+	seen[i] = true
+	methods := append([]*Method{}, i.Methods...)
+	for _, e := range i.Embeds {
+		if e == "error" {
+			methods = append(methods, &Method{Name: "Error", File: i.File, Results: []*Type{{T: "string"}}})
+			continue
+		}
+		embedded := getInterface(embeddedInterface(i, e))
+		if embedded == nil {
+			logMsg("[Interface] Skipped methods of " + e + " embedded in " + i.Name)
+			continue
+		}
+		methods = append(methods, interfaceMethods(embedded, seen)...)
+	}
+	return methods
+}
+
+// flattenInterfaces adds the methods of the embedded interfaces to the
+// interfaces of every package, only the embedded interfaces which are not
+// parsed are kept in Embeds.
+func flattenInterfaces() {
+	for _, pkg := range pkgs {
+		for _, i := range pkg.Interfaces {
+			var methods []*Method
+			for _, m := range interfaceMethods(i, nil) {
+				found := false
+				for _, v := range methods {
+					found = found || v.Name == m.Name
+				}
+				if !found {
+					methods = append(methods, m)
+				}
+			}
+			var embeds []string
+			for _, e := range i.Embeds {
+				if p, name := embeddedInterface(i, e); e != "error" && getInterface(p, name) == nil {
+					embeds = append(embeds, e)
+				}
+			}
+			i.Methods, i.Embeds = methods, embeds
+		}
+	}
+}
+
+// embeddedInterface returns the package and the name of the interface e
+// embedded in i.
+func embeddedInterface(i *Interface, e string) (pkg string, name string) {
+	pkg, name = i.File.Package, e
+	if strings.Contains(e, ".") {
+		pkg, name = strings.Split(e, ".")[0], strings.Split(e, ".")[1]
+		if path, ok := i.File.Imports[pkg]; ok {
+			pkg = importName(path)
+			if n := loadPackage(path); n != "" {
+				pkg = n
+			}
+		}
+	}
+	return
+}
+
+func getInterface(pkg, name string) *Interface {
+	_, i := getStructOrInterface(name, pkg)
+	return i
+}
+
+// signature returns the parameter and result types of m with every type
+// qualified by its package so methods declared in different packages compare.
+func signature(m *Method) string {
+	var params, results []string
+	for _, t := range m.Params {
+		params = append(params, qualifyType(t.T, m.File))
+	}
+	for _, t := range m.Results {
+		results = append(results, qualifyType(t.T, m.File))
+	}
+	sig := "(" + strings.Join(params, ", ") + ")"
+	if len(results) == 1 {
+		sig += " " + results[0]
+	} else if len(results) > 1 {
+		sig += " (" + strings.Join(results, ", ") + ")"
+	}
+	return sig
+}
+
+// qualifyType prefixes the types declared in the package of file with the
+// package name and the imported ones with the name of their import path.
+func qualifyType(t string, file *CodeFile) string {
+	variadic := strings.HasPrefix(t, "...")
+	expr, err := parser.ParseExpr(strings.TrimPrefix(t, "..."))
+	if err != nil || file == nil {
+		return t
+	}
+	var qualify func(n ast.Node) bool
+	qualify = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Field:
+			ast.Inspect(n.Type, qualify)
+			return false
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok {
+				if path, ok := file.Imports[x.Name]; ok {
+					x.Name = importName(path)
+					if n := loaded[path]; n != "" {
+						x.Name = n
+					}
+				}
+			}
+			return false
+		case *ast.Ident:
+			if types.Universe.Lookup(n.Name) == nil {
+				n.Name = file.Package + "." + n.Name
+			}
+		}
+		return true
+	}
+	ast.Inspect(expr, qualify)
+	buf := new(strings.Builder)
+	printer.Fprint(buf, token.NewFileSet(), expr)
+	if variadic {
+		return "..." + buf.String()
+	}
+	return buf.String()
+}
+
+// implementsAssertion returns the compile time check that s implements i,
+// written in the package pkg.
+func implementsAssertion(s *Struct, i *Interface, pkg string) string {
+	st, it := s.Name, i.Name
+	if s.File.Package != pkg {
+		st = s.File.Package + "." + st
+	}
+	if i.File.Package != pkg {
+		it = i.File.Package + "." + it
+	}
+	return "\nvar _ " + it + " = (*" + st + ")(nil)\n"
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// duplicates holds the declarations replacing an earlier one with the same
+// key, the test declarations replacing the ones of di.go and the declarations
+// replacing a directive are not held as they do it on purpose.
+var duplicates []string
+
+// Lint prints the duplicate declarations, the shares and bindings which no
+// di.Build uses and the handwritten New<Name> functions shadowing the
+// constructor a di.Build declares. It exits with 1 when there are some.
+func Lint(dir, mod string) {
+	trace(dir, mod)
+	problems := append([]string{}, duplicates...)
+
+	used := make(map[*Di]bool)
+	bound := make(map[string]bool)
+	var walk func(n *traceNode)
+	walk = func(n *traceNode) {
+		if n.decl != nil {
+			used[n.decl] = true
+			if n.decl.inter != "" {
+				bound[n.decl.inter] = true
+			}
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	for _, root := range traces {
+		if root.decl != nil && root.decl.method == "Build" {
+			walk(root)
+		}
+	}
+
+	for _, d := range diassignments {
+		switch d.method {
+		case "Share", "Config":
+			if !used[d] {
+				problems = append(problems, describe(d)+" shares "+d.src+" which no di.Build uses")
+			}
+		case "Bind", "BindEnv":
+			if !bound[d.inter] {
+				problems = append(problems, describe(d)+" binds "+d.inter+" which no di.Build uses")
+			}
+		case "Build":
+			if met := getMethod("New"+identName(d.src), d.pkg); met != nil {
+				problems = append(problems, met.Name+" at "+met.Pos+" shadows the constructor declared by "+describe(d))
+			}
+		}
+	}
+	sort.Strings(problems)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
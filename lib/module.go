@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// workspace maps the paths of the modules used by the go.work file, and of
+// the module being generated, to their directories.
+var workspace map[string]string
+
+// workReplaces holds the replace directives of the go.work file, they take
+// precedence over the ones of the go.mod files.
+var workReplaces []*modfile.Replace
+
+// loadWorkspace finds the module enclosing dir and the go.work file using it.
+func loadWorkspace(dir string) {
+	workspace = make(map[string]string)
+	workReplaces = nil
+	if work := findWorkFile(dir); work != "" {
+		data, err := os.ReadFile(work)
+		if err != nil {
+			fmt.Println("go.work file is not readable", err)
+			os.Exit(1)
+		}
+		wf, err := modfile.ParseWork(work, data, nil)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, u := range wf.Use {
+			d := u.Path
+			if !filepath.IsAbs(d) {
+				d = filepath.Join(filepath.Dir(work), d)
+			}
+			if p := modulePathOf(d); p != "" {
+				workspace[p] = d
+			}
+		}
+		workReplaces = wf.Replace
+		logMsg("[Module] Using " + work)
+	}
+	if modulePath != "" {
+		workspace[modulePath] = moduleRoot
+	} else if moduleRoot != "" {
+		workspace[modulePathOf(moduleRoot)] = moduleRoot
+	}
+}
+
+// findWorkFile returns the go.work file of dir following GOWORK.
+func findWorkFile(dir string) string {
+	switch gowork := os.Getenv("GOWORK"); gowork {
+	case "off":
+		return ""
+	case "":
+	default:
+		return gowork
+	}
+	dir, _ = filepath.Abs(dir)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.work")); err == nil {
+			return filepath.Join(dir, "go.work")
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// findModuleRoot returns the nearest directory containing a go.mod file
+// from dir upwards.
+func findModuleRoot(dir string) string {
+	dir, _ = filepath.Abs(dir)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// modulePathOf returns the path declared by the go.mod file of root.
+func modulePathOf(root string) string {
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	return modfile.ModulePath(data)
+}
+
+// importPath returns the import path of the package in dir from the module
+// enclosing it, the module being generated is named modulePath.
+func importPath(dir string) string {
+	abs, _ := filepath.Abs(dir)
+	root := findModuleRoot(abs)
+	if root == "" {
+		return ""
+	}
+	path := modulePathOf(root)
+	if root == moduleRoot && modulePath != "" {
+		path = modulePath
+	}
+	rel, _ := filepath.Rel(root, abs)
+	if rel == "." {
+		return path
+	}
+	return path + "/" + filepath.ToSlash(rel)
+}
+
+// workspaceDir returns the directory of the package imported as path when
+// it belongs to one of the modules of the workspace.
+func workspaceDir(path string) string {
+	best := ""
+	for p := range workspace {
+		if (path == p || strings.HasPrefix(path, p+"/")) && len(p) > len(best) {
+			best = p
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return existingDir(filepath.Join(workspace[best], strings.TrimPrefix(path[len(best):], "/")))
+}
+
+// scanRoots returns the directories scanned for dir, dir itself when it is
+// part of a module and the modules of the workspace below it, which
+// traversDir skips as nested modules.
+func scanRoots(dir string) []string {
+	var roots, used []string
+	if findModuleRoot(dir) != "" {
+		roots = append(roots, dir)
+	}
+	abs, _ := filepath.Abs(dir)
+	for _, d := range workspace {
+		if d, _ = filepath.Abs(d); strings.HasPrefix(d, abs+string(filepath.Separator)) {
+			used = append(used, d)
+		}
+	}
+	sort.Strings(used)
+	return append(roots, used...)
+}
@@ -17,12 +17,32 @@ type CodeFile struct {
 	Methods    []*Method
 	Interfaces []*Interface
 	Imports    map[string]string
+	Vars       map[string]string
+	// Names holds every name declared at the top level of the file.
+	Names []string
+	// Directives holds the //di: comments of the declarations of the file.
+	Directives []*Directive
+}
+
+// Directive is a //di: comment declaring a dependency in the doc comment of
+// a type, function or variable, for e.g. //di:bind UserServicer.
+type Directive struct {
+	Name   string
+	Args   []string
+	Target string
+	// Kind is the kind of declaration commented, type, func or var.
+	Kind string
+	Pos  string
 }
 
 type Interface struct {
 	File    *CodeFile
 	Name    string
 	Methods []*Method
+	// Embeds holds the interfaces embedded in the interface as written, for e.g. io.Reader.
+	Embeds []string
+	// TypeParams holds the names of the type parameters of a generic interface.
+	TypeParams []string
 }
 
 type Type struct {
@@ -35,6 +55,8 @@ type Field struct {
 	Name   string
 	Type   string
 	Tag    string
+	// Embedded is set for embedded fields, whose methods are promoted.
+	Embedded bool
 }
 
 type Method struct {
@@ -43,12 +65,22 @@ type Method struct {
 	Params   []*Type
 	Results  []*Type
 	Reciever *Type
+	// Provide is set for functions having a //di:provide directive.
+	Provide bool
+	Pos     string
 }
 
 type Struct struct {
 	Name   string
 	File   *CodeFile
 	Fields []*Field
+	// TypeParams holds the names of the type parameters of a generic struct
+	// and TypeArgs the type arguments of its instantiations.
+	TypeParams []string
+	TypeArgs   []string
+	// Underlying is set for named types which are not structs, for e.g.
+	// int for type Port int, and for the unnamed types of shared values.
+	Underlying string
 }
 type ParseVisitor struct {
 	v    int
@@ -64,25 +96,75 @@ func getTypes(list *ast.FieldList) []*Type {
 		fset := token.NewFileSet()
 		buf := new(strings.Builder)
 		printer.Fprint(buf, fset, f.Type)
-		n := ""
-		if len(f.Names) > 0 {
-			n = f.Names[0].Name
+		if len(f.Names) == 0 {
+			ts = append(ts, &Type{T: buf.String()})
 		}
-		t := Type{
-			Name: n,
-			T:    buf.String(),
+		for _, n := range f.Names {
+			ts = append(ts, &Type{Name: n.Name, T: buf.String()})
 		}
-		ts = append(ts, &t)
 	}
 	return ts
 
 }
+func typeParams(spec *ast.TypeSpec) []string {
+	var params []string
+	if spec.TypeParams == nil {
+		return params
+	}
+	for _, f := range spec.TypeParams.List {
+		for _, n := range f.Names {
+			params = append(params, n.Name)
+		}
+	}
+	return params
+}
+
+// directives returns the //di: comments of the doc comment of the
+// declaration target.
+func directives(doc *ast.CommentGroup, target, kind string) []*Directive {
+	var ds []*Directive
+	if doc == nil {
+		return ds
+	}
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, "//di:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(c.Text, "//di:"))
+		if len(fields) == 0 {
+			continue
+		}
+		ds = append(ds, &Directive{Name: fields[0], Args: fields[1:], Target: target, Kind: kind, Pos: fileSet.Position(c.Pos()).String()})
+	}
+	return ds
+}
+
 func (v ParseVisitor) Visit(n ast.Node) ast.Visitor {
 
 	if n == nil {
 		return nil
 	}
 	switch n.(type) {
+	case *ast.GenDecl:
+		gen := n.(*ast.GenDecl)
+		if len(gen.Specs) == 1 {
+			// the doc comment of a single declaration is the one of its spec.
+			switch spec := gen.Specs[0].(type) {
+			case *ast.TypeSpec:
+				if spec.Doc == nil {
+					spec.Doc = gen.Doc
+				}
+			case *ast.ValueSpec:
+				if spec.Doc == nil {
+					spec.Doc = gen.Doc
+				}
+			}
+		}
+	case *ast.ValueSpec:
+		vs := n.(*ast.ValueSpec)
+		for _, name := range vs.Names {
+			v.file.Directives = append(v.file.Directives, directives(vs.Doc, name.Name, "var")...)
+		}
 	case *ast.ImportSpec:
 		name := ""
 		if n.(*ast.ImportSpec).Name != nil {
@@ -91,13 +173,7 @@ func (v ParseVisitor) Visit(n ast.Node) ast.Visitor {
 		}
 		path := strings.Trim(n.(*ast.ImportSpec).Path.Value, "\"")
 		if name == "" {
-			idx := strings.LastIndex(path, "/") + 1
-			if idx < 0 {
-				name = path
-			} else {
-				name = path[idx:]
-
-			}
+			name = importName(path)
 		}
 		v.file.Imports[name] = path
 	case *ast.FuncDecl:
@@ -107,57 +183,96 @@ func (v ParseVisitor) Visit(n ast.Node) ast.Visitor {
 			File:    v.file,
 			Params:  getTypes(f.Type.Params),
 			Results: getTypes(f.Type.Results),
+			Pos:     fileSet.Position(f.Pos()).String(),
 		}
 		ts := getTypes(f.Recv)
 
 		if len(ts) > 0 {
 			m.Reciever = ts[0]
 		}
+		for _, d := range directives(f.Doc, f.Name.Name, "func") {
+			m.Provide = m.Provide || d.Name == "provide"
+			v.file.Directives = append(v.file.Directives, d)
+		}
 
 		v.file.Methods = append(v.file.Methods, &m)
 	case *ast.TypeSpec:
 		tSpec := n.(*ast.TypeSpec)
+		v.file.Directives = append(v.file.Directives, directives(tSpec.Doc, tSpec.Name.Name, "type")...)
 		sType, ok := tSpec.Type.(*ast.StructType)
 		if ok {
 			s := Struct{
-				Name:   tSpec.Name.Name,
-				File:   v.file,
-				Fields: make([]*Field, 0),
+				Name:       tSpec.Name.Name,
+				File:       v.file,
+				Fields:     make([]*Field, 0),
+				TypeParams: typeParams(tSpec),
 			}
 			for _, f := range sType.Fields.List {
 				fset := token.NewFileSet()
 				buf := new(strings.Builder)
 				printer.Fprint(buf, fset, f.Type)
 
-				fs := Field{
-					Struct: &s,
-					Name:   f.Names[0].Name,
-					Type:   buf.String(),
+				var names []string
+				for _, n := range f.Names {
+					names = append(names, n.Name)
+				}
+				if len(names) == 0 {
+					// embedded fields are named after their type.
+					t := strings.TrimPrefix(buf.String(), "*")
+					names = append(names, t[strings.LastIndex(t, ".")+1:])
 				}
-				if f.Tag != nil {
-					fs.Tag = f.Tag.Value
+				for _, n := range names {
+					fs := Field{
+						Struct:   &s,
+						Name:     n,
+						Type:     buf.String(),
+						Embedded: len(f.Names) == 0,
+					}
+					if f.Tag != nil {
+						fs.Tag = f.Tag.Value
+					}
+					s.Fields = append(s.Fields, &fs)
 				}
-				s.Fields = append(s.Fields, &fs)
 			}
 			v.file.Structs = append(v.file.Structs, &s)
 
 		}
+		switch tSpec.Type.(type) {
+		case *ast.StructType, *ast.InterfaceType:
+		default:
+			if !tSpec.Assign.IsValid() {
+				buf := new(strings.Builder)
+				printer.Fprint(buf, token.NewFileSet(), tSpec.Type)
+				v.file.Structs = append(v.file.Structs, &Struct{
+					Name:       tSpec.Name.Name,
+					File:       v.file,
+					TypeParams: typeParams(tSpec),
+					Underlying: buf.String(),
+				})
+			}
+		}
 		iType, ok := tSpec.Type.(*ast.InterfaceType)
 		if ok {
 			s := Interface{
-				Name:    tSpec.Name.Name,
-				File:    v.file,
-				Methods: make([]*Method, 0),
+				Name:       tSpec.Name.Name,
+				File:       v.file,
+				Methods:    make([]*Method, 0),
+				TypeParams: typeParams(tSpec),
 			}
 			for _, f := range iType.Methods.List {
 				m, ok := f.Type.(*ast.FuncType)
 				if ok {
 					fs := Method{
 						Name:    f.Names[0].Name,
+						File:    v.file,
 						Params:  getTypes(m.Params),
 						Results: getTypes(m.Results),
 					}
 					s.Methods = append(s.Methods, &fs)
+				} else {
+					buf := new(strings.Builder)
+					printer.Fprint(buf, token.NewFileSet(), f.Type)
+					s.Embeds = append(s.Embeds, buf.String())
 				}
 			}
 			v.file.Interfaces = append(v.file.Interfaces, &s)
@@ -170,10 +285,11 @@ func (v ParseVisitor) Visit(n ast.Node) ast.Visitor {
 
 }
 
-func parseFile(file string) *ast.File {
-	fset := token.NewFileSet()
+// fileSet holds the positions of every parsed file.
+var fileSet = token.NewFileSet()
 
-	f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+func parseFile(file string) *ast.File {
+	f, err := parser.ParseFile(fileSet, file, nil, parser.ParseComments)
 	if err != nil {
 		fmt.Println("parsing file err", err, f)
 	}
@@ -182,8 +298,7 @@ func parseFile(file string) *ast.File {
 
 func parseGoFile(file string) *CodeFile {
 
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+	f, err := parser.ParseFile(fileSet, file, nil, parser.ParseComments)
 	if err != nil {
 		fmt.Println("parsing file err", err, f)
 	}
@@ -202,5 +317,72 @@ func NewCodeFile(f *ast.File, path string) *CodeFile {
 		file: &codeFile,
 	}
 	ast.Walk(p, f)
+	codeFile.Vars = getVars(f)
+	codeFile.Names = declaredNames(f)
 	return &codeFile
 }
+
+// declaredNames returns the names of the types, functions, variables and
+// constants declared at the top level of f.
+func declaredNames(f *ast.File) []string {
+	var names []string
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				names = append(names, decl.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, spec.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						names = append(names, n.Name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// getVars returns the types of the package level variables declared in f,
+// either written out or taken from a composite literal value.
+func getVars(f *ast.File) map[string]string {
+	vars := make(map[string]string)
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, n := range vs.Names {
+				var t ast.Expr = vs.Type
+				if t == nil && i < len(vs.Values) {
+					t = vs.Values[i]
+				}
+				if u, ok := t.(*ast.UnaryExpr); ok && u.Op == token.AND {
+					t = &ast.StarExpr{X: u.X}
+				}
+				if star, ok := t.(*ast.StarExpr); ok {
+					if cl, ok := star.X.(*ast.CompositeLit); ok {
+						t = &ast.StarExpr{X: cl.Type}
+					}
+				}
+				if cl, ok := t.(*ast.CompositeLit); ok {
+					t = cl.Type
+				}
+				if t == nil {
+					continue
+				}
+				buf := new(strings.Builder)
+				printer.Fprint(buf, token.NewFileSet(), t)
+				vars[n.Name] = buf.String()
+			}
+		}
+	}
+	return vars
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+)
+
+// building holds the names of the values being generated, the last one is
+// the value whose dependencies are resolved.
+var building []string
+
+// users holds the values using each value of the function being generated.
+var users = make(map[string][]string)
+
+// overridable holds the values of the function being generated which can be
+// replaced through a With<Name> option.
+var overridable = make(map[string]bool)
+
+// buildNode makes name the value being generated until the returned function
+// is called, recording that the value generated before uses it.
+func buildNode(name string) func() {
+	if len(building) > 0 {
+		users[name] = append(users[name], building[len(building)-1])
+	}
+	building = append(building, name)
+	return func() { building = building[:len(building)-1] }
+}
+
+// pruneScope starts recording the values of a new generated function, the
+// returned function restores the ones of the enclosing function.
+func pruneScope() func() {
+	b, u, o := building, users, overridable
+	building, users, overridable = nil, make(map[string][]string), make(map[string]bool)
+	return func() { building, users, overridable = b, u, o }
+}
+
+// buildVar returns the variable telling whether the value name is built,
+// false when it is overridden or only used by overridden values.
+func buildVar(name string) string {
+	return "diBuild" + strings.ToUpper(name[0:1]) + name[1:]
+}
+
+// buildCondition returns the expression telling whether a value used by the
+// values names is needed, true when one of them is always built.
+func buildCondition(names []string) string {
+	var conds []string
+	for _, u := range removeDuplicateStr(names) {
+		c := "true"
+		if overridable[u] {
+			c = buildVar(u)
+		} else if len(users[u]) > 0 {
+			c = buildCondition(users[u])
+		}
+		if c == "true" {
+			return c
+		}
+		conds = append(conds, c)
+	}
+	if len(conds) == 0 {
+		return "true"
+	}
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return "(" + strings.Join(conds, " || ") + ")"
+}
+
+// buildConditions declares the build variable of every overridable value of
+// the function being generated, after the ones of the values using it.
+func buildConditions() string {
+	code := ""
+	done := make(map[string]bool)
+	var declare func(name string)
+	declare = func(name string) {
+		if done[name] {
+			return
+		}
+		done[name] = true
+		for _, u := range users[name] {
+			declare(u)
+		}
+		if !overridable[name] {
+			return
+		}
+		cond := "diO." + name + " == nil"
+		if c := buildCondition(users[name]); c != "true" {
+			cond = c + " && " + cond
+		}
+		code += buildVar(name) + " := " + cond + "\n"
+	}
+	var names []string
+	for n := range overridable {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		declare(n)
+	}
+	return code
+}
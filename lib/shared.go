@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+)
+
+// getAccessor returns the function creating the shared value typ of the
+// package pkg on first use. The parameters of a provider given as a function
+// are built from the graph, the ones which can not be built become arguments
+// of the accessor.
+func getAccessor(pkg, typ string) Function {
+	key := receiver + typ
+	if fn, ok := pkgs[pkg].Accessors[key]; ok {
+		return fn
+	}
+	defer pruneScope()()
+	name := getVarName(typ)
+	call := pkgs[pkg].Providers[typ]
+	results := []*Type{{T: typ}}
+	var code, args, imports, returns []string
+	if _, ok := pkgs[pkg].Configs[typ]; ok {
+		results = []*Type{{T: "*" + typ}, {T: "error"}}
+	}
+	if d, ok := diassignments[typ]; ok {
+		imports = append(imports, d.imports...)
+	}
+	if met := getProvider(call, pkg); met != nil {
+		results = nil
+		for _, r := range met.Results {
+			results = append(results, &Type{Name: r.Name, T: localType(r.T, met.File, pkg)})
+			imports = append(imports, localImports(r.T, met.File, pkg)...)
+		}
+		if !strings.Contains(call, "(") {
+			var ar []string
+			for _, p := range met.Params {
+				t, spread := variadicParam(p.T)
+				ref, co, a, im, ret := resolveType(met.File, paramName(name, p), t, pkg, nil)
+				code = append(code, co...)
+				args = append(args, a...)
+				imports = append(imports, im...)
+				returns = append(returns, ret...)
+				ar = append(ar, ref+spread)
+			}
+			call += "(" + strings.Join(ar, ", ") + ")"
+		}
+	}
+	c, hasErr := constructorCall(results, call, name, true)
+	code = append(code, c)
+	if hasErr {
+		returns = append(returns, "err error")
+	}
+	returns = removeDuplicateStr(returns)
+	args = removeDuplicateStr(args)
+	sort.Strings(args)
+
+	body := buildConditions() + strings.Join(removeDuplicateStr(code), "\n")
+	if usesOptions(body) {
+		body = "diO := newDiOptions(nil)\n" + body
+	}
+	var ar []string
+	for _, arg := range args {
+		ar = append(ar, strings.Split(arg, " ")[0])
+	}
+	recv := ""
+	if receiver != "" {
+		recv = "(c *Container) "
+	}
+	fn := Function{
+		name:    "shared" + identName(typ),
+		args:    args,
+		ret:     []string{"*" + typ},
+		imports: imports,
+		method:  receiver != "",
+	}
+	set := receiver + name
+	if len(returns) > 0 {
+		fn.ret = append(fn.ret, "error")
+		set += ", " + receiver + name + "Err"
+	}
+	rets := append([]string{name + " *" + typ}, returns...)
+	fn.code = generateFunction(recv+"newShared"+identName(typ), body, strings.Join(args, ", "), "("+strings.Join(rets, ", ")+")", "")
+	fn.code += `
+	// ` + fn.name + ` returns the shared ` + typ + `, creating it on first use.
+	func ` + recv + fn.name + `(` + strings.Join(args, ", ") + `) (` + strings.Join(fn.ret, ", ") + `) {
+		` + receiver + name + `Once.Do(func() {
+			` + set + ` = ` + receiver + `newShared` + identName(typ) + `(` + strings.Join(ar, ", ") + `)
+		})
+		return ` + set + `
+	}
+	`
+	pkgs[pkg].Accessors[key] = fn
+	return fn
+}
+
+// prepareAccessors generates the accessors of every shared value before the
+// files are written, as building their dependencies can add constructors to
+// other packages.
+func prepareAccessors() {
+	var names []string
+	for n := range pkgs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		pkg := pkgs[n]
+		for typ := range pkg.Providers {
+			if !hasMethods(pkg) || hasFunctions(pkg) {
+				receiver = ""
+				getAccessor(n, typ)
+			}
+			if hasMethods(pkg) {
+				receiver = "c."
+				getAccessor(n, typ)
+			}
+		}
+	}
+	receiver = ""
+}
+
+// getSharedFields declares the variables holding the shared values of the
+// package, as package level variables or as fields of the Container.
+func getSharedFields(pkg Package) string {
+	code := ""
+	for _, n := range getProviderNames(pkg) {
+		code += getVarName(n) + " *" + n + "\n" + getVarName(n) + "Once sync.Once\n"
+		for _, fn := range pkg.Accessors {
+			if fn.name == "shared"+identName(n) && len(fn.ret) > 1 {
+				code += getVarName(n) + "Err error\n"
+				break
+			}
+		}
+	}
+	return code
+}
+
+// getSharedAccessors generates the package level variables and accessors of
+// the shared values when recv is empty, or the Container methods otherwise.
+func getSharedAccessors(pkg Package, recv string) []byte {
+	code := ""
+	if recv == "" && len(pkg.Providers) > 0 {
+		code = "var (\n" + getSharedFields(pkg) + ")\n"
+	}
+	for _, n := range getProviderNames(pkg) {
+		code += pkg.Accessors[recv+n].code
+	}
+	return []byte(code)
+}
+
+func getProviderNames(pkg Package) []string {
+	var names []string
+	for n := range pkg.Providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
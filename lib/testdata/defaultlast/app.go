@@ -0,0 +1,5 @@
+package defaultlast
+
+type Server struct {
+	Hosts []string `di:"default=a,b,env=HOSTS"`
+}
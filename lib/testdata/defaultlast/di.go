@@ -0,0 +1,9 @@
+//go:build exclude
+
+package defaultlast
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func init() {
+	di.Build(Server{})
+}
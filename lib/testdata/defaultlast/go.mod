@@ -0,0 +1,3 @@
+module defaultlast
+
+go 1.19
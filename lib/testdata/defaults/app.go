@@ -0,0 +1,6 @@
+package defaults
+
+type Server struct {
+	Port  int      `di:"default=80,env=PORT"`
+	Hosts []string `di:"env=HOSTS,default=a,b"`
+}
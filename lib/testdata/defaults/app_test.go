@@ -0,0 +1,14 @@
+package defaults
+
+import "testing"
+
+func TestDefaults(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Port != 8080 || len(s.Hosts) != 2 {
+		t.Errorf("got %+v", s)
+	}
+}
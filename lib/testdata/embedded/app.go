@@ -0,0 +1,27 @@
+package embedded
+
+type Logger interface{ Log(string) }
+
+type Base struct{ Prefix string }
+
+func (*Base) Log(string) {}
+
+// Svc has Log as it embeds *Base.
+type Svc struct{ *Base }
+
+type Inner struct{}
+
+func (*Inner) Log(string) {}
+
+// Val has Log by pointer only as it embeds Inner.
+type Val struct{ Inner }
+
+type Handler struct {
+	L Logger
+}
+
+type Printer interface{ Log(string) }
+
+type Screen struct {
+	P Printer
+}
@@ -0,0 +1,12 @@
+//go:build exclude
+
+package embedded
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func init() {
+	di.Bind(Logger, Svc{})
+	di.Bind(Printer, Val{})
+	di.Build(Handler{})
+	di.Build(Screen{})
+}
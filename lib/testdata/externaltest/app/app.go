@@ -0,0 +1,9 @@
+package app
+
+type Servicer interface{ Get() string }
+
+type Service struct{}
+
+func (Service) Get() string { return "service" }
+
+type Handler struct{ S Servicer }
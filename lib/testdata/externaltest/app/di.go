@@ -0,0 +1,10 @@
+//go:build exclude
+
+package app
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func init() {
+	di.Bind(Servicer, Service{})
+	di.Build(Handler{})
+}
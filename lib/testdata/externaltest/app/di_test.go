@@ -0,0 +1,13 @@
+//go:build exclude
+
+package app_test
+
+import (
+	"externaltest/app"
+
+	"github.com/siddhesh-tamhanekar/di"
+)
+
+func init() {
+	di.Bind(app.Servicer, FakeService{})
+}
@@ -0,0 +1,5 @@
+package app_test
+
+type FakeService struct{}
+
+func (FakeService) Get() string { return "fake" }
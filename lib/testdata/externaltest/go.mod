@@ -0,0 +1,3 @@
+module externaltest
+
+go 1.19
@@ -0,0 +1,9 @@
+package generics
+
+import "generics/repo"
+
+type User struct{ Name string }
+
+type App struct {
+	R repo.Repo[User]
+}
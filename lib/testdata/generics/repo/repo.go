@@ -0,0 +1,10 @@
+package repo
+
+type Config struct{ Dsn string }
+
+type Repo[T any] struct {
+	Items  []T
+	Config Config
+}
+
+func (r *Repo[T]) Init() {}
@@ -0,0 +1,26 @@
+package override
+
+import "errors"
+
+type Db struct{ Dsn string }
+
+func NewDb(dsn string) (*Db, error) { return nil, errors.New("no database") }
+
+type Servicer interface{ Get() string }
+
+type Service struct {
+	Db   Db
+	Port int `di:"env=PORT,default=80"`
+}
+
+func (Service) Get() string { return "service" }
+
+type Handler struct{ S Servicer }
+
+// Inner and App have fields named like the options of the constructors.
+type Inner struct{ Opts []string }
+
+type App struct {
+	I Inner
+	O string
+}
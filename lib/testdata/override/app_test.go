@@ -0,0 +1,23 @@
+package override
+
+import "testing"
+
+type fakeService struct{}
+
+func (fakeService) Get() string { return "fake" }
+
+func TestOverride(t *testing.T) {
+	h, err := NewHandler("", WithServicer(fakeService{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.S.Get(); got != "fake" {
+		t.Errorf("got %s, want fake", got)
+	}
+	if _, err := NewHandler(""); err == nil {
+		t.Error("NewDb is not called")
+	}
+	if a := NewApp("o", []string{"a"}); a.O != "o" || a.I.Opts[0] != "a" {
+		t.Errorf("got %+v", a)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build exclude
+
+package override
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func init() {
+	di.Bind(Servicer, Service{})
+	di.Build(Handler{})
+	di.Build(App{})
+}
@@ -0,0 +1,3 @@
+module override
+
+go 1.19
@@ -0,0 +1,15 @@
+package overrides
+
+type Servicer interface{ Get() string }
+
+//di:bind Servicer
+type Service struct{}
+
+func (Service) Get() string { return "service" }
+
+type Other struct{}
+
+func (Other) Get() string { return "other" }
+
+//di:build
+type Handler struct{ S Servicer }
@@ -0,0 +1,9 @@
+//go:build exclude
+
+package overrides
+
+import "github.com/siddhesh-tamhanekar/di"
+
+func init() {
+	di.Bind(Servicer, Fake{})
+}
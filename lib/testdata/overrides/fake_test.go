@@ -0,0 +1,5 @@
+package overrides
+
+type Fake struct{}
+
+func (Fake) Get() string { return "fake" }
@@ -0,0 +1,3 @@
+module overrides
+
+go 1.19
@@ -0,0 +1,20 @@
+package variadic
+
+import "strings"
+
+type Option func(*Client)
+
+type Client struct{ Name string }
+
+func NewClient(name string, opts ...Option) Client {
+	c := Client{Name: name}
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
+
+type App struct {
+	C Client
+	R *strings.Replacer
+}
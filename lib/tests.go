@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+)
+
+// testWiring is set while the injectors declared by the di_test.go files are
+// generated into di_gen_test.go.
+var testWiring bool
+
+// testPkgs holds the packages having a di_test.go file, the ones declared in
+// an external _test package are held under the name of the tested package.
+var testPkgs map[string]bool
+
+// renameDecls renames every top level declaration of the generated source b
+// with testName so it does not clash with the di_gen.go of the package.
+func renameDecls(b []byte) []byte {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "di_gen_test.go", b, parser.ParseComments)
+	if err != nil {
+		return b
+	}
+	for _, decl := range f.Decls {
+		var doc *ast.CommentGroup
+		var name string
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				doc, name = decl.Doc, decl.Name.Name
+			}
+		case *ast.GenDecl:
+			if spec, ok := decl.Specs[0].(*ast.TypeSpec); ok && len(decl.Specs) == 1 {
+				doc, name = decl.Doc, spec.Name.Name
+			}
+		}
+		if doc != nil {
+			c := doc.List[0]
+			c.Text = strings.Replace(c.Text, "// "+name+" ", "// "+testName(name)+" ", 1)
+		}
+	}
+	keys := make(map[*ast.Ident]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if kv, ok := n.(*ast.KeyValueExpr); ok {
+			if id, ok := kv.Key.(*ast.Ident); ok {
+				// keys of struct literals are field names
+				keys[id] = true
+			}
+		}
+		return true
+	})
+	ast.Inspect(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Obj != nil && f.Scope.Lookup(id.Name) == id.Obj && !keys[id] {
+			id.Name = testName(id.Name)
+		}
+		return true
+	})
+	buf := new(bytes.Buffer)
+	if err := format.Node(buf, fset, f); err != nil {
+		return b
+	}
+	return buf.Bytes()
+}
+
+// testName returns the name of the test declaration named name, for e.g.
+// NewTestApp for NewApp, WithTestDb for WithDb, newTestDiOptions for
+// newDiOptions, TestOption for Option and testDiOptions for diOptions.
+func testName(name string) string {
+	for _, prefix := range []string{"New", "With", "new"} {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return prefix + "Test" + name[len(prefix):]
+		}
+	}
+	if ast.IsExported(name) {
+		return "Test" + name
+	}
+	return "test" + strings.ToUpper(name[0:1]) + name[1:]
+}
+
+// checkExternalTests stops the generation when a di_test.go declaration uses
+// a type declared in an external _test package, the injectors are generated
+// in the tested package which can not refer to it.
+func checkExternalTests() {
+	for _, d := range diassignments {
+		if !d.test {
+			continue
+		}
+		names := []string{d.src}
+		for _, expr := range d.fields {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(expr, "&"), "{}"))
+		}
+		for _, n := range names {
+			if s, i := getStructOrInterface(n, d.pkg); s != nil || i != nil {
+				continue
+			}
+			if s, i := getStructOrInterface(n, d.pkg+"_test"); s != nil || i != nil {
+				fmt.Println(n + " used at " + d.pos + " is declared in the external test package " + d.pkg + "_test, the test injectors are generated in package " + d.pkg + ", declare it in a _test.go file of package " + d.pkg)
+				os.Exit(1)
+			}
+		}
+	}
+}